@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Active Directory reports why a bind was rejected as a hex sub-code in the
+// diagnostic message, e.g.
+// "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 532, v4563".
+var adSubCodePattern = regexp.MustCompile(`\bdata ([0-9a-fA-F]+)\b`)
+
+var adSubCodes = map[string]LDAPErrorCode{
+	"525": ErrInvalidCredentials, // user not found, reported as a bad password
+	"52e": ErrInvalidCredentials,
+	"530": ErrInvalidLogonHours,
+	"531": ErrWorkstationRestricted,
+	"532": ErrPasswordExpired,
+	"533": ErrAccountDisabled,
+	"701": ErrAccountExpired,
+	"773": ErrPasswordMustChange,
+	"775": ErrAccountLocked,
+}
+
+// bindError turns a rejected user bind into an LDAPError. Only
+// invalidCredentials (49) says anything about the user: its AD sub-code
+// gives the account state, and without one it is a bad password. Other
+// failures are faults of the server or connection, reported as
+// ErrBindFailed or ErrConnectionFailed so they are not counted as failed
+// logins.
+func bindError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*LDAPError); ok {
+		return err
+	}
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		var netErr net.Error
+		if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) || errors.As(err, &netErr) {
+			return NewLDAPErrorWithCause(ErrConnectionFailed, "user bind failed", err)
+		}
+		return NewLDAPErrorWithCause(ErrBindFailed, "user bind failed", err)
+	}
+	code := ErrInvalidCredentials
+	if m := adSubCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		if c, ok := adSubCodes[strings.ToLower(m[1])]; ok {
+			code = c
+		}
+	}
+	return NewLDAPErrorWithCause(code, "user bind rejected", err)
+}
+
+// exposedAuthError returns the error code to show the caller for a rejected
+// user bind. Codes outside AUTH_EXPOSE_ERRORS are reported as
+// invalid_credentials so account state is not disclosed.
+func exposedAuthError(cfg *Config, code LDAPErrorCode) string {
+	for _, c := range cfg.AuthExposeErrors {
+		if strings.EqualFold(c, string(code)) {
+			return string(code)
+		}
+	}
+	return string(ErrInvalidCredentials)
+}
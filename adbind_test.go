@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestBindErrorADSubCodes(t *testing.T) {
+	tests := []struct {
+		data string
+		want LDAPErrorCode
+	}{
+		{"52e", ErrInvalidCredentials},
+		{"525", ErrInvalidCredentials},
+		{"530", ErrInvalidLogonHours},
+		{"531", ErrWorkstationRestricted},
+		{"532", ErrPasswordExpired},
+		{"533", ErrAccountDisabled},
+		{"701", ErrAccountExpired},
+		{"773", ErrPasswordMustChange},
+		{"775", ErrAccountLocked},
+		{"52E", ErrInvalidCredentials},
+		{"999", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		msg := "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data " + tt.data + ", v4563"
+		err := bindError(ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New(msg)))
+		if got := GetLDAPErrorCode(err); got != tt.want {
+			t.Errorf("data %s: code = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestBindErrorNonAD(t *testing.T) {
+	if err := bindError(nil); err != nil {
+		t.Fatalf("bindError(nil) = %v", err)
+	}
+	// OpenLDAP has no sub-code
+	err := bindError(ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("")))
+	if got := GetLDAPErrorCode(err); got != ErrInvalidCredentials {
+		t.Errorf("code = %q, want invalid_credentials", got)
+	}
+}
+
+func TestBindErrorServerFaults(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want LDAPErrorCode
+	}{
+		// a "data" sub-code on a different result code is not decoded
+		{ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("data 532")), ErrBindFailed},
+		{ldap.NewError(ldap.LDAPResultBusy, errors.New("busy")), ErrBindFailed},
+		{ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset")), ErrConnectionFailed},
+		{&net.OpError{Op: "read", Err: errors.New("broken pipe")}, ErrConnectionFailed},
+		{NewLDAPError(ErrBindFailed, "connection does not support NTLM bind"), ErrBindFailed},
+	} {
+		if got := GetLDAPErrorCode(bindError(tc.err)); got != tc.want {
+			t.Errorf("bindError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestExposedAuthError(t *testing.T) {
+	cfg := &Config{AuthExposeErrors: []string{"password_expired", "Account_Locked"}}
+	for code, want := range map[LDAPErrorCode]string{
+		ErrPasswordExpired:    "password_expired",
+		ErrAccountLocked:      "account_locked",
+		ErrAccountDisabled:    "invalid_credentials",
+		ErrInvalidCredentials: "invalid_credentials",
+	} {
+		if got := exposedAuthError(cfg, code); got != want {
+			t.Errorf("exposedAuthError(%s) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestAuthHandlerBindFaultNotCounted(t *testing.T) {
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.bindFn = func(username, _ string) error {
+			if strings.HasPrefix(username, "CN=Alice") {
+				return ldap.NewError(ldap.LDAPResultBusy, errors.New("server busy"))
+			}
+			return nil
+		}
+	})
+	now := time.Now()
+	app.throttle = testThrottler(&now)
+
+	w := httptest.NewRecorder()
+	AuthHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(`{"username":"alice","password":"pw"}`)))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "ldap_client_error") {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if e := app.throttle.entries[ThrottleUser]["alice"]; e != nil && (len(e.failures) > 0 || e.inFlight > 0) {
+		t.Errorf("server fault recorded by the throttle: %+v", e)
+	}
+}
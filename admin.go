@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminActorHeader optionally names the operator behind an admin request,
+// e.g. the helpdesk agent using a shared tool. It is recorded in the audit
+// trail next to the API key fingerprint.
+const AdminActorHeader = "X-Admin-Actor"
+
+type adminActorKey struct{}
+
+// requireAdmin only lets requests through that present one of the
+// configured admin API keys as "Authorization: Bearer <key>".
+func requireAdmin(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !validAPIKey(cfg.AdminAPIKeys, key) {
+			log.Warn().Str("ip", clientIP(cfg, r)).Str("path", r.URL.Path).Msg("rejected admin request")
+			respondJSON(w, http.StatusUnauthorized, map[string]any{"ok": false, "error": "unauthorized"})
+			return
+		}
+		actor := "key:" + keyFingerprint(key)
+		if name := r.Header.Get(AdminActorHeader); name != "" {
+			actor = name + " (" + actor + ")"
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), adminActorKey{}, actor)))
+	}
+}
+
+// adminActor returns the actor set by requireAdmin.
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(adminActorKey{}).(string)
+	return actor
+}
+
+// keyFingerprint identifies an API key in logs without revealing it.
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// validAPIKey reports whether key is one of keys.
+func validAPIKey(keys []string, key string) bool {
+	if key == "" {
+		return false
+	}
+	valid := false
+	for _, k := range keys {
+		// compare against every key so timing does not reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// GET /v1/admin/lockouts
+func LockoutsHandler(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]any{"ok": true, "lockouts": app.throttle.Lockouts()})
+	}
+}
+
+// DELETE /v1/admin/lockouts/{type}/{key}
+func ClearLockoutHandler(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		outcome := outcomeSuccess
+		defer func() {
+			ev := auditEventFromRequest(app.cfg, r, AuditEventClearLockout)
+			ev.Actor, ev.Target = adminActor(r), vars["type"]+":"+vars["key"]
+			if vars["type"] == ThrottleUser {
+				ev.Username = vars["key"]
+			}
+			auditOutcome(&ev, outcome)
+			app.audit.Record(ev)
+		}()
+
+		if !app.throttle.Clear(vars["type"], vars["key"]) {
+			outcome = "not_found"
+			respondJSON(w, http.StatusNotFound, map[string]any{"ok": false, "error": "not_found"})
+			return
+		}
+		log.Info().Str("type", vars["type"]).Str("key", vars["key"]).Str("actor", adminActor(r)).Msg("lockout cleared by admin")
+		respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+type PasswordResetRequest struct {
+	NewPassword string `json:"new_password"`
+	MustChange  bool   `json:"must_change"` // 下次登录时必须修改密码
+}
+
+// POST /v1/admin/users/{username}/password
+func PasswordResetHandler(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PasswordResetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error": "invalid_json", "detail": err.Error()})
+			return
+		}
+		if req.NewPassword == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]any{"ok": false, "error": "missing_password"})
+			return
+		}
+		adminUserAction(app, AuditEventPasswordReset, func(ctx context.Context, r *http.Request, client *LDAPClient, userDN string) (int, error) {
+			if err := client.ResetPassword(ctx, userDN, req.NewPassword, req.MustChange); err != nil {
+				switch GetLDAPErrorCode(err) {
+				case ErrPasswordPolicy, ErrPasswordInHistory, ErrPasswordTooYoung:
+					return http.StatusBadRequest, err
+				case ErrPasswordChangeDenied:
+					return http.StatusForbidden, err
+				}
+				return http.StatusInternalServerError, err
+			}
+			return http.StatusOK, nil
+		})(w, r)
+	}
+}
+
+// POST /v1/admin/users/{username}/unlock
+func UnlockHandler(app *App) http.HandlerFunc {
+	return adminUserAction(app, AuditEventUnlock, func(ctx context.Context, r *http.Request, client *LDAPClient, userDN string) (int, error) {
+		if err := client.Unlock(ctx, userDN); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		// 同时清除本服务的本地锁定
+		if app.throttle != nil {
+			app.throttle.Clear(ThrottleUser, mux.Vars(r)["username"])
+		}
+		return http.StatusOK, nil
+	})
+}
+
+// adminUserAction resolves the {username} of an admin route with the pooled
+// service account connection, runs action against it and audits the result
+// with the acting admin.
+func adminUserAction(app *App, event string, action func(ctx context.Context, r *http.Request, client *LDAPClient, userDN string) (int, error)) http.HandlerFunc {
+	cfg, pool := app.cfg, app.pool
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+		var userDN string
+		outcome := outcomeInternalError
+		defer func() {
+			ev := auditEventFromRequest(cfg, r, event)
+			ev.Username, ev.UserDN, ev.Actor = username, userDN, adminActor(r)
+			auditOutcome(&ev, outcome)
+			app.audit.Record(ev)
+		}()
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		client, err := pool.Get(ctx)
+		if err != nil {
+			outcome = authOutcome(err, ErrConnectionFailed)
+			log.Error().Err(err).Msg("failed to get ldap connection")
+			respondJSON(w, http.StatusInternalServerError, map[string]any{"ok": false, "error": "ldap_client_error"})
+			return
+		}
+		defer pool.Put(client)
+
+		userDN, _, err = client.FindUserDN(ctx, username)
+		if err != nil {
+			outcome = authOutcome(err, ErrSearchFailed)
+			status := http.StatusInternalServerError
+			if GetLDAPErrorCode(err) == ErrUserNotFound {
+				status = http.StatusNotFound
+			}
+			respondJSON(w, status, map[string]any{"ok": false, "error": outcome})
+			return
+		}
+
+		status, err := action(ctx, r, client, userDN)
+		if err != nil {
+			outcome = authOutcome(err, ErrSearchFailed)
+			log.Warn().Err(err).Str("event", event).Str("userDN", userDN).Str("actor", adminActor(r)).Msg("admin action failed")
+			respondJSON(w, status, map[string]any{"ok": false, "error": outcome})
+			return
+		}
+		log.Info().Str("event", event).Str("userDN", userDN).Str("actor", adminActor(r)).Msg("admin action performed")
+		outcome = outcomeSuccess
+		respondJSON(w, status, map[string]any{"ok": true})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/gorilla/mux"
+)
+
+// memAuditSink keeps events in memory for assertions.
+type memAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *memAuditSink) Write(ev AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *memAuditSink) Close() error { return nil }
+
+func TestRequireAdmin(t *testing.T) {
+	cfg := &Config{AdminAPIKeys: []string{"k1", "k2"}}
+	h := requireAdmin(cfg, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	for auth, want := range map[string]int{
+		"":          http.StatusUnauthorized,
+		"Bearer":    http.StatusUnauthorized,
+		"Bearer k3": http.StatusUnauthorized,
+		"Basic k1":  http.StatusUnauthorized,
+		"Bearer k2": http.StatusNoContent,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/admin/lockouts", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, w.Code, want)
+		}
+	}
+}
+
+func TestResetPasswordAD(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryAD}, conn: fc}
+	if err := c.ResetPassword(context.Background(), "CN=Alice,DC=example,DC=com", "new", true); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if len(fc.modifies) != 1 {
+		t.Fatalf("expected one modify, got %d", len(fc.modifies))
+	}
+	ch := fc.modifies[0].Changes
+	if len(ch) != 2 || ch[0].Modification.Type != "unicodePwd" || ch[0].Modification.Vals[0] != adPassword("new") ||
+		ch[1].Modification.Type != "pwdLastSet" || ch[1].Modification.Vals[0] != "0" {
+		t.Errorf("unexpected changes %+v", ch)
+	}
+}
+
+func TestResetPasswordOpenLDAP(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryOpenLDAP}, conn: fc}
+	if err := c.ResetPassword(context.Background(), "uid=alice,dc=example,dc=com", "new", true); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if len(fc.passwordModifies) != 1 || fc.passwordModifies[0].OldPassword != "" || fc.passwordModifies[0].NewPassword != "new" {
+		t.Fatalf("unexpected password modifies %+v", fc.passwordModifies)
+	}
+	if len(fc.modifies) != 1 || fc.modifies[0].Changes[0].Modification.Type != "pwdReset" {
+		t.Fatalf("expected pwdReset to be set, got %+v", fc.modifies)
+	}
+
+	fc = &fakeConn{}
+	c.conn = fc
+	if err := c.ResetPassword(context.Background(), "uid=alice,dc=example,dc=com", "new", false); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if len(fc.modifies) != 0 {
+		t.Errorf("pwdReset set without must_change")
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryAD}, conn: fc}
+	if err := c.Unlock(context.Background(), "CN=Alice,DC=example,DC=com"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if m := fc.modifies[0].Changes[0]; m.Operation != ldap.ReplaceAttribute || m.Modification.Type != "lockoutTime" || m.Modification.Vals[0] != "0" {
+		t.Errorf("unexpected AD change %+v", m)
+	}
+
+	// OpenLDAP reports noSuchAttribute when the account was not locked
+	fc = &fakeConn{modifyErr: ldap.NewError(ldap.LDAPResultNoSuchAttribute, errors.New("modify/delete: pwdAccountLockedTime: no such attribute"))}
+	c = &LDAPClient{cfg: &Config{DirectoryType: DirectoryOpenLDAP}, conn: fc}
+	if err := c.Unlock(context.Background(), "uid=alice,dc=example,dc=com"); err != nil {
+		t.Fatalf("Unlock of an unlocked account: %v", err)
+	}
+	if m := fc.modifies[0].Changes[0]; m.Operation != ldap.DeleteAttribute || m.Modification.Type != "pwdAccountLockedTime" {
+		t.Errorf("unexpected OpenLDAP change %+v", m)
+	}
+
+	fc.modifyErr = ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New(""))
+	if err := c.Unlock(context.Background(), "uid=alice,dc=example,dc=com"); GetLDAPErrorCode(err) != ErrUnlockFailed {
+		t.Errorf("Unlock error = %v, want unlock_failed", err)
+	}
+}
+
+func adminTestApp(t *testing.T, setup func(*fakeConn)) (*App, *fakeDialer, *memAuditSink) {
+	app, d := passwordChangeTestApp(t, setup)
+	app.cfg.AdminAPIKeys = []string{"k1"}
+	sink := &memAuditSink{}
+	app.audit = &Auditor{sinks: []AuditSink{sink}}
+	return app, d, sink
+}
+
+func serveAdmin(app *App, method, path, body string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/admin/users/{username}/password", requireAdmin(app.cfg, PasswordResetHandler(app))).Methods("POST")
+	router.HandleFunc("/v1/admin/users/{username}/unlock", requireAdmin(app.cfg, UnlockHandler(app))).Methods("POST")
+	router.HandleFunc("/v1/admin/lockouts/{type}/{key}", requireAdmin(app.cfg, ClearLockoutHandler(app))).Methods("DELETE")
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer k1")
+	r.Header.Set(AdminActorHeader, "helpdesk.bob")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestPasswordResetHandlerAudit(t *testing.T) {
+	app, d, sink := adminTestApp(t, func(*fakeConn) {})
+
+	w := serveAdmin(app, http.MethodPost, "/v1/admin/users/alice/password", `{"new_password":"N3w!","must_change":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if n := len(d.conns[0].modifies); n != 1 {
+		t.Fatalf("expected one modify, got %d", n)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("expected one audit event, got %d", len(sink.events))
+	}
+	ev := sink.events[0]
+	want := "helpdesk.bob (key:" + keyFingerprint("k1") + ")"
+	if ev.Event != AuditEventPasswordReset || ev.Username != "alice" || ev.UserDN != "CN=Alice,DC=example,DC=com" || ev.Actor != want || ev.Outcome != AuditSuccess {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+	if strings.Contains(w.Body.String(), "N3w!") {
+		t.Error("response echoes the password")
+	}
+}
+
+func TestPasswordResetHandlerPolicyViolation(t *testing.T) {
+	app, _, sink := adminTestApp(t, func(fc *fakeConn) {
+		fc.modifyErr = ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("0000052D: Constraint violation"))
+	})
+
+	w := serveAdmin(app, http.MethodPost, "/v1/admin/users/alice/password", `{"new_password":"x"}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrPasswordPolicy)) {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if ev := sink.events[0]; ev.Outcome != AuditFailure || ev.ErrorCode != string(ErrPasswordPolicy) {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+}
+
+func TestUnlockHandlerUnknownUser(t *testing.T) {
+	app, _, sink := adminTestApp(t, func(fc *fakeConn) {
+		fc.searchFn = func(*ldap.SearchRequest) (*ldap.SearchResult, error) { return &ldap.SearchResult{}, nil }
+	})
+
+	w := serveAdmin(app, http.MethodPost, "/v1/admin/users/nobody/unlock", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if ev := sink.events[0]; ev.Event != AuditEventUnlock || ev.ErrorCode != string(ErrUserNotFound) {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+}
+
+func TestClearLockoutHandlerAudit(t *testing.T) {
+	app, _, sink := adminTestApp(t, func(*fakeConn) {})
+	now := time.Now()
+	app.throttle = testThrottler(&now)
+	app.throttle.Failure("alice", "10.0.0.1")
+
+	if w := serveAdmin(app, http.MethodDelete, "/v1/admin/lockouts/user/alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if w := serveAdmin(app, http.MethodDelete, "/v1/admin/lockouts/ip/10.0.0.9", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if len(sink.events) != 2 {
+		t.Fatalf("expected two audit events, got %d", len(sink.events))
+	}
+	actor := "helpdesk.bob (key:" + keyFingerprint("k1") + ")"
+	if ev := sink.events[0]; ev.Event != AuditEventClearLockout || ev.Target != "user:alice" || ev.Username != "alice" || ev.Actor != actor || ev.Outcome != AuditSuccess {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+	if ev := sink.events[1]; ev.Target != "ip:10.0.0.9" || ev.Actor != actor || ev.Outcome != AuditFailure || ev.ErrorCode != "not_found" {
+		t.Errorf("unexpected audit event %+v", ev)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+// App bundles the long-lived dependencies shared by the HTTP handlers.
+type App struct {
+	cfg    *Config
+	pool   *LDAPPool
+	tokens *TokenIssuer // nil when token issuance is disabled
+	audit  *Auditor
+	// throttle is nil when rate limiting is disabled
+	throttle *Throttler
+	// cursors holds unfinished paged directory searches
+	cursors *cursorStore
+}
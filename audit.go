@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Audit event types
+const (
+	AuditEventAuth           = "auth"
+	AuditEventPasswordChange = "password_change"
+	AuditEventPasswordReset  = "password_reset"
+	AuditEventUnlock         = "unlock"
+	AuditEventClearLockout   = "clear_lockout"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is one line of the security audit trail. It must never carry
+// passwords or other secrets.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Username  string    `json:"username,omitempty"`
+	UserDN    string    `json:"user_dn,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	ClientApp string    `json:"client_app,omitempty"`
+	Actor     string    `json:"actor,omitempty"`  // who performed an admin action
+	Target    string    `json:"target,omitempty"` // throttle key cleared by an admin, as "<type>:<key>"
+	Outcome   string    `json:"outcome"`
+	ErrorCode string    `json:"error_code,omitempty"`
+}
+
+// AuditSink receives audit events.
+type AuditSink interface {
+	Write(ev AuditEvent) error
+	Close() error
+}
+
+// Auditor fans events out to the configured sinks. A zero Auditor records
+// nothing.
+type Auditor struct {
+	sinks []AuditSink
+
+	// mu keeps Close from running while events are written: a request that
+	// outlives its configuration's grace period may still record after the
+	// auditor was closed, which must not reach a closed sink.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewAuditor(cfg *Config) (*Auditor, error) {
+	a := &Auditor{}
+	for _, name := range cfg.AuditSinks {
+		var sink AuditSink
+		var err error
+		switch strings.ToLower(name) {
+		case "file":
+			sink = newFileAuditSink(cfg)
+		case "syslog":
+			sink, err = newSyslogAuditSink(cfg)
+		case "webhook":
+			sink, err = newWebhookAuditSink(cfg)
+		default:
+			err = fmt.Errorf("unknown audit sink %q", name)
+		}
+		if err != nil {
+			a.Close()
+			return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "failed to set up audit sink "+name, err)
+		}
+		a.sinks = append(a.sinks, sink)
+	}
+	return a, nil
+}
+
+// Record writes ev to every sink. Sink errors are logged to the application
+// log rather than failing the request.
+func (a *Auditor) Record(ev AuditEvent) {
+	if a == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		log.Error().Str("event", ev.Event).Str("username", ev.Username).Str("outcome", ev.Outcome).Msg("audit log closed, event dropped")
+		return
+	}
+	for _, s := range a.sinks {
+		if err := s.Write(ev); err != nil {
+			log.Error().Err(err).Str("event", ev.Event).Msg("failed to write audit event")
+		}
+	}
+}
+
+func (a *Auditor) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	a.closed = true
+	for _, s := range a.sinks {
+		_ = s.Close()
+	}
+}
+
+// auditEventFromRequest fills the request-derived fields of an event.
+func auditEventFromRequest(cfg *Config, r *http.Request, event string) AuditEvent {
+	return AuditEvent{
+		Event:     event,
+		SourceIP:  clientIP(cfg, r),
+		UserAgent: r.UserAgent(),
+		ClientApp: r.Header.Get(ClientAppHeader),
+	}
+}
+
+// auditOutcome splits a metrics outcome into audit outcome and error code.
+func auditOutcome(ev *AuditEvent, outcome string) {
+	if outcome == outcomeSuccess {
+		ev.Outcome = AuditSuccess
+		return
+	}
+	ev.Outcome = AuditFailure
+	ev.ErrorCode = outcome
+}
+
+// fileAuditSink appends JSON lines to a file with its own rotation
+// settings, independent of LOG_FILE.
+type fileAuditSink struct {
+	mu sync.Mutex
+	w  *lumberjack.Logger
+}
+
+func newFileAuditSink(cfg *Config) *fileAuditSink {
+	return &fileAuditSink{w: &lumberjack.Logger{
+		Filename:   cfg.AuditFile,
+		MaxSize:    cfg.AuditFileMaxSizeMB,
+		MaxBackups: cfg.AuditFileMaxBackups,
+		MaxAge:     cfg.AuditFileMaxAgeDays,
+		Compress:   cfg.AuditFileCompress,
+	}}
+}
+
+func (s *fileAuditSink) Write(ev AuditEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileAuditSink) Close() error {
+	return s.w.Close()
+}
+
+// webhookAuditSink POSTs each event as JSON. Delivery is asynchronous so a
+// slow receiver does not delay logins; events are dropped (and logged) when
+// the queue is full.
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+	queue  chan AuditEvent
+	done   chan struct{}
+}
+
+const webhookQueueSize = 1000
+
+func newWebhookAuditSink(cfg *Config) (*webhookAuditSink, error) {
+	if cfg.AuditWebhookURL == "" {
+		return nil, fmt.Errorf("AUDIT_WEBHOOK_URL is required for the webhook sink")
+	}
+	s := &webhookAuditSink{
+		url:    cfg.AuditWebhookURL,
+		client: &http.Client{Timeout: cfg.AuditWebhookTimeout},
+		queue:  make(chan AuditEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *webhookAuditSink) Write(ev AuditEvent) error {
+	select {
+	case s.queue <- ev:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue full, event dropped")
+	}
+}
+
+func (s *webhookAuditSink) run() {
+	defer close(s.done)
+	for ev := range s.queue {
+		if err := s.post(ev); err != nil {
+			log.Error().Err(err).Str("event", ev.Event).Msg("failed to deliver audit event to webhook")
+		}
+	}
+}
+
+func (s *webhookAuditSink) post(ev AuditEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close flushes queued events.
+func (s *webhookAuditSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogAuditSink sends each event as a JSON message with the authpriv
+// facility.
+type syslogAuditSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogAuditSink(cfg *Config) (AuditSink, error) {
+	w, err := syslog.Dial(cfg.AuditSyslogNetwork, cfg.AuditSyslogAddr, syslog.LOG_AUTHPRIV|syslog.LOG_INFO, cfg.AuditSyslogTag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditSink{w: w}, nil
+}
+
+func (s *syslogAuditSink) Write(ev AuditEvent) error {
+	msg, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if ev.Outcome == AuditFailure {
+		return s.w.Warning(string(msg))
+	}
+	return s.w.Info(string(msg))
+}
+
+func (s *syslogAuditSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// log/syslog is not available on Windows.
+func newSyslogAuditSink(cfg *Config) (AuditSink, error) {
+	return nil, errors.New("syslog audit sink is not supported on Windows")
+}
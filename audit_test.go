@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := NewAuditor(&Config{AuditSinks: []string{"file"}, AuditFile: path, AuditFileMaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("NewAuditor: %v", err)
+	}
+
+	a.Record(AuditEvent{Event: AuditEventAuth, Username: "alice", Outcome: AuditSuccess})
+	a.Record(AuditEvent{Event: AuditEventAuth, Username: "bob", Outcome: AuditFailure, ErrorCode: "invalid_credentials"})
+	a.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit file: %v", err)
+	}
+	defer f.Close()
+	var events []AuditEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("audit line is not JSON: %q", sc.Text())
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 2 || events[1].Username != "bob" || events[1].ErrorCode != "invalid_credentials" {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if events[0].Time.IsZero() {
+		t.Error("expected timestamp to be filled in")
+	}
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	received := make(chan AuditEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ev AuditEvent
+		_ = json.Unmarshal(body, &ev)
+		received <- ev
+	}))
+	defer srv.Close()
+
+	a, err := NewAuditor(&Config{AuditSinks: []string{"webhook"}, AuditWebhookURL: srv.URL, AuditWebhookTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewAuditor: %v", err)
+	}
+	a.Record(AuditEvent{Event: AuditEventAuth, Username: "alice", Outcome: AuditSuccess})
+	a.Close()
+
+	select {
+	case ev := <-received:
+		if ev.Username != "alice" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("webhook did not receive the event")
+	}
+}
+
+func TestAuditorRecordAfterClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	a, err := NewAuditor(&Config{AuditSinks: []string{"webhook"}, AuditWebhookURL: srv.URL, AuditWebhookTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewAuditor: %v", err)
+	}
+
+	// 旧配置在宽限期后关闭审计日志时，仍在运行的请求可能继续记录
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				a.Record(AuditEvent{Event: AuditEventAuth, Username: "alice", Outcome: AuditFailure})
+			}
+		}()
+	}
+	a.Close()
+	wg.Wait()
+	a.Record(AuditEvent{Event: AuditEventAuth, Username: "alice", Outcome: AuditSuccess})
+	a.Close()
+}
+
+func TestNewAuditorRejectsUnknownSink(t *testing.T) {
+	if _, err := NewAuditor(&Config{AuditSinks: []string{"kafka"}}); GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestClientIPForwardedFor(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/auth", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	// 198.51.100.9 是客户端伪造的，203.0.113.7 由可信代理 10.0.0.1 添加
+	r.Header.Set("X-Forwarded-For", "198.51.100.9, 203.0.113.7, 10.0.0.1")
+
+	for name, tc := range map[string]struct {
+		cfg  Config
+		want string
+	}{
+		"headers not trusted": {Config{}, "10.0.0.5"},
+		"peer only":           {Config{TrustProxyHeaders: true}, "10.0.0.1"},
+		"proxy cidr":          {Config{TrustProxyHeaders: true, TrustedProxies: []string{"10.0.0.0/8"}}, "203.0.113.7"},
+		"proxy address":       {Config{TrustProxyHeaders: true, TrustedProxies: []string{"10.0.0.5", "10.0.0.1"}}, "203.0.113.7"},
+		"untrusted peer":      {Config{TrustProxyHeaders: true, TrustedProxies: []string{"192.168.0.0/16"}}, "10.0.0.5"},
+	} {
+		if got := clientIP(&tc.cfg, r); got != tc.want {
+			t.Errorf("%s: clientIP = %s, want %s", name, got, tc.want)
+		}
+	}
+}
+
+func TestAuditEventFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/auth", strings.NewReader(`{"username":"alice","password":"secret"}`))
+	r.RemoteAddr = "10.0.0.5:51234"
+	r.Header.Set("User-Agent", "portal/1.0")
+	r.Header.Set(ClientAppHeader, "portal")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	ev := auditEventFromRequest(&Config{}, r, AuditEventAuth)
+	if ev.SourceIP != "10.0.0.5" || ev.UserAgent != "portal/1.0" || ev.ClientApp != "portal" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev := auditEventFromRequest(&Config{TrustProxyHeaders: true, TrustedProxies: []string{"10.0.0.0/8"}}, r, AuditEventAuth); ev.SourceIP != "203.0.113.7" {
+		t.Errorf("expected forwarded client IP, got %s", ev.SourceIP)
+	}
+
+	auditOutcome(&ev, "invalid_credentials")
+	line, _ := json.Marshal(ev)
+	if strings.Contains(string(line), "secret") {
+		t.Error("audit event must not contain the password")
+	}
+	if ev.Outcome != AuditFailure || ev.ErrorCode != "invalid_credentials" {
+		t.Errorf("unexpected outcome %s/%s", ev.Outcome, ev.ErrorCode)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// ClientAppHeader identifies the calling application, used to pick a
+// per-client authorization rule. The header is not authenticated, so tokens
+// issued under a client's own rule carry that client as their audience.
+const ClientAppHeader = "X-Client-App"
+
+// AuthzRule restricts authentication to members of certain groups. Entries
+// match a group by DN or by name, case-insensitively.
+type AuthzRule struct {
+	Allow []string `yaml:"allow_groups"` // 为空表示不限制
+	Deny  []string `yaml:"deny_groups"`  // 优先于 Allow
+}
+
+// Empty reports whether the rule places no restriction.
+func (r AuthzRule) Empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0
+}
+
+// Permits reports whether a user with the given groups passes the rule.
+func (r AuthzRule) Permits(groups []Group) bool {
+	if matchesAnyGroup(r.Deny, groups) {
+		return false
+	}
+	return len(r.Allow) == 0 || matchesAnyGroup(r.Allow, groups)
+}
+
+func matchesAnyGroup(patterns []string, groups []Group) bool {
+	for _, p := range patterns {
+		for _, g := range groups {
+			if strings.EqualFold(p, g.DN) || strings.EqualFold(p, g.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthzRuleFor returns the rule for a client application, falling back to
+// the default rule when the client has none of its own. A client rule
+// replaces the default allow list but always includes the default deny
+// list: the client comes from an unauthenticated header, so naming another
+// client must not get a denied user past it.
+func (c *Config) AuthzRuleFor(client string) AuthzRule {
+	def := c.AuthzRules[""]
+	key := c.AuthzClient(client)
+	if key == "" {
+		return def
+	}
+	r := c.AuthzRules[key]
+	r.Deny = append(slices.Clip(r.Deny), def.Deny...)
+	return r
+}
+
+// AuthzClient returns the normalised name of the client whose own rule
+// applies, or "" when the default rule applies.
+func (c *Config) AuthzClient(client string) string {
+	if client == "" {
+		return ""
+	}
+	if _, ok := c.AuthzRules[authzClientKey(client)]; ok {
+		return authzClientKey(client)
+	}
+	return ""
+}
+
+// authzClientKey normalises a client name so that the header value
+// "hr-portal" matches AUTHZ_CLIENT_HR_PORTAL_*.
+func authzClientKey(client string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(client)), "-", "_")
+}
+
+// loadAuthzRulesFromEnv reads AUTHZ_ALLOW_GROUPS / AUTHZ_DENY_GROUPS for the
+// default rule and AUTHZ_CLIENT_<NAME>_ALLOW_GROUPS / _DENY_GROUPS for
+// per-client rules. Group lists are separated by ";" because DNs contain
+// commas.
+func loadAuthzRulesFromEnv() map[string]AuthzRule {
+	rules := map[string]AuthzRule{}
+	set := func(client string, allow bool, v string) {
+		r := rules[client]
+		if allow {
+			r.Allow = splitListSep(v, ";")
+		} else {
+			r.Deny = splitListSep(v, ";")
+		}
+		rules[client] = r
+	}
+
+	if v := os.Getenv("AUTHZ_ALLOW_GROUPS"); v != "" {
+		set("", true, v)
+	}
+	if v := os.Getenv("AUTHZ_DENY_GROUPS"); v != "" {
+		set("", false, v)
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, "AUTHZ_CLIENT_") || v == "" {
+			continue
+		}
+		name := strings.TrimPrefix(k, "AUTHZ_CLIENT_")
+		switch {
+		case strings.HasSuffix(name, "_ALLOW_GROUPS"):
+			set(authzClientKey(strings.TrimSuffix(name, "_ALLOW_GROUPS")), true, v)
+		case strings.HasSuffix(name, "_DENY_GROUPS"):
+			set(authzClientKey(strings.TrimSuffix(name, "_DENY_GROUPS")), false, v)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testGroups = []Group{
+	{DN: "cn=staff,ou=groups,dc=example,dc=com", Name: "staff"},
+	{DN: "cn=contractors,ou=groups,dc=example,dc=com", Name: "contractors"},
+}
+
+func TestAuthzRulePermits(t *testing.T) {
+	tests := []struct {
+		name string
+		rule AuthzRule
+		want bool
+	}{
+		{"no rule", AuthzRule{}, true},
+		{"allowed by name", AuthzRule{Allow: []string{"Staff"}}, true},
+		{"allowed by DN", AuthzRule{Allow: []string{"CN=staff,OU=groups,DC=example,DC=com"}}, true},
+		{"not in allow list", AuthzRule{Allow: []string{"admins"}}, false},
+		{"denied", AuthzRule{Deny: []string{"contractors"}}, false},
+		{"deny wins over allow", AuthzRule{Allow: []string{"staff"}, Deny: []string{"contractors"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Permits(testGroups); got != tt.want {
+				t.Errorf("Permits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if (AuthzRule{Allow: []string{"staff"}}).Permits(nil) {
+		t.Error("user without groups should not pass an allow list")
+	}
+}
+
+func TestAuthzRuleFor(t *testing.T) {
+	cfg := &Config{AuthzRules: map[string]AuthzRule{
+		"":          {Allow: []string{"staff"}, Deny: []string{"contractors"}},
+		"hr_portal": {Allow: []string{"hr"}},
+		"wiki":      {Deny: []string{"interns"}},
+	}}
+	if got := cfg.AuthzRuleFor("HR-Portal"); !reflect.DeepEqual(got.Allow, []string{"hr"}) {
+		t.Errorf("expected client rule, got %v", got)
+	}
+	// 客户端规则替换默认允许列表，但默认拒绝列表始终生效
+	if got := cfg.AuthzRuleFor("wiki"); !reflect.DeepEqual(got.Deny, []string{"interns", "contractors"}) || got.Permits(testGroups) {
+		t.Errorf("client rule should include the default deny list, got %v", got)
+	}
+	if got := cfg.AuthzRules["wiki"].Deny; len(got) != 1 {
+		t.Errorf("configured client rule modified: %v", got)
+	}
+	if got := cfg.AuthzRuleFor("crm"); !reflect.DeepEqual(got.Allow, []string{"staff"}) {
+		t.Errorf("expected default rule for unknown client, got %v", got)
+	}
+	if got := (&Config{}).AuthzRuleFor("wiki"); !got.Empty() {
+		t.Errorf("expected empty rule without configuration, got %v", got)
+	}
+	if got := cfg.AuthzClient("HR-Portal"); got != "hr_portal" {
+		t.Errorf("AuthzClient(HR-Portal) = %q", got)
+	}
+	if got := cfg.AuthzClient("crm"); got != "" {
+		t.Errorf("AuthzClient(crm) = %q, want default rule", got)
+	}
+}
+
+func TestLoadAuthzRulesFromEnv(t *testing.T) {
+	vars := map[string]string{
+		"AUTHZ_ALLOW_GROUPS":                  "cn=staff,ou=groups,dc=example,dc=com; admins",
+		"AUTHZ_DENY_GROUPS":                   "contractors",
+		"AUTHZ_CLIENT_HR_PORTAL_ALLOW_GROUPS": "hr",
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}()
+
+	rules := loadAuthzRulesFromEnv()
+	def := rules[""]
+	if !reflect.DeepEqual(def.Allow, []string{"cn=staff,ou=groups,dc=example,dc=com", "admins"}) {
+		t.Errorf("unexpected default allow list %v", def.Allow)
+	}
+	if !reflect.DeepEqual(def.Deny, []string{"contractors"}) {
+		t.Errorf("unexpected default deny list %v", def.Deny)
+	}
+	if got := rules["hr_portal"].Allow; !reflect.DeepEqual(got, []string{"hr"}) {
+		t.Errorf("unexpected client allow list %v", got)
+	}
+}
+
+func TestValidateAuthzRulesNeedGroupMode(t *testing.T) {
+	c := defaultConfig()
+	c.AuthzRules = map[string]AuthzRule{
+		"":          {Deny: []string{"contractors"}},
+		"hr_portal": {Allow: []string{"hr"}},
+		"wiki":      {},
+	}
+	err := c.Validate()
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+	if !strings.Contains(problems[0], "the default group authorization rule") || !strings.Contains(problems[1], "the hr_portal group authorization rule") {
+		t.Errorf("problems = %q", problems)
+	}
+
+	c.GroupMode = GroupModeMemberOf
+	if err := c.Validate(); err != nil {
+		t.Errorf("rules with LDAP_GROUP_MODE rejected: %v", err)
+	}
+}
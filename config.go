@@ -1,56 +1,443 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	ServicePort        string
-	LDAPURL            string
-	BindDN             string
-	BindPassword       string
-	UserSearchBase     string
-	UserSearchFilter   string // e.g. "(uid=%s)" or "(sAMAccountName=%s)"
-	UserDNAttr         string // optional
-	ReturnAttributes   []string
-	ConnTimeout        time.Duration
-	RequestTimeout     time.Duration
-	UseLDAPS           bool
-	UseStartTLS        bool
-	InsecureSkipVerify bool
-	BasePath           string // URL 路径前缀，例如 "/api" 或 "/ldap"
-	LogLevel           string // 日志级别: debug, info, warn, error
-	LogFile            string // 日志文件路径，为空则只输出到控制台
-}
-
-func LoadConfigFromEnv() *Config {
+	ServicePort        string        `yaml:"service_port"`
+	LDAPURL            string        `yaml:"ldap_url"`
+	LDAPURLs           []string      `yaml:"ldap_urls"` // 多个服务器时使用，优先于 LDAPURL
+	BindDN             string        `yaml:"ldap_bind_dn"`
+	BindPassword       string        `yaml:"ldap_bind_password"`
+	BindMethod         string        `yaml:"ldap_bind_method"`       // simple, external (SASL EXTERNAL，使用 TLS 客户端证书) 或 ntlm
+	BindNTHash         string        `yaml:"ldap_bind_nt_hash"`      // ntlm 绑定时代替密码的 NT hash (hex)
+	UserNTLMDomains    []string      `yaml:"ldap_user_ntlm_domains"` // 这些域的 DOMAIN\user 用户名以 NTLM 校验密码
+	UserSearchBase     string        `yaml:"ldap_user_base"`
+	UserSearchFilter   string        `yaml:"ldap_user_filter"`    // e.g. "(uid=%s)" or "(sAMAccountName=%s)"
+	UserDNAttr         string        `yaml:"ldap_user_dn_attr"`   // optional
+	DirectoryType      string        `yaml:"ldap_directory_type"` // openldap 或 ad，决定修改密码的方式
+	ReturnAttributes   []string      `yaml:"ldap_return_attributes"`
+	ConnTimeout        time.Duration `yaml:"ldap_conn_timeout"`
+	RequestTimeout     time.Duration `yaml:"ldap_request_timeout"`
+	UseLDAPS           bool          `yaml:"ldap_use_ldaps"`
+	UseStartTLS        bool          `yaml:"ldap_use_starttls"`
+	InsecureSkipVerify bool          `yaml:"ldap_insecure_skip_verify"`
+	BasePath           string        `yaml:"base_path"`       // URL 路径前缀，例如 "/api" 或 "/ldap"
+	LogLevel           string        `yaml:"log_level"`       // 日志级别: debug, info, warn, error
+	LogFile            string        `yaml:"log_file"`        // 日志文件路径，为空则只输出到控制台
+	MetricsEnabled     bool          `yaml:"metrics_enabled"` // 是否暴露 /metrics
+
+	// NetBIOS 域 -> 该域用户的搜索基准，LDAP_USER_NTLM_DOMAINS 有多个域时每个域都需要
+	UserNTLMBases map[string]string `yaml:"ldap_user_ntlm_bases"`
+
+	// LDAPS / StartTLS 的 TLS 配置
+	LDAPTLSCAFile       string   `yaml:"ldap_tls_ca_file"`       // 信任的 CA 证书 (PEM)，设置后替代系统 CA
+	LDAPTLSCert         string   `yaml:"ldap_tls_cert"`          // 客户端证书，目录服务器要求客户端证书时使用
+	LDAPTLSKey          string   `yaml:"ldap_tls_key"`           // 客户端证书私钥
+	LDAPTLSServerName   string   `yaml:"ldap_tls_server_name"`   // 校验证书使用的主机名，默认取自 URL
+	LDAPTLSMinVersion   string   `yaml:"ldap_tls_min_version"`   // 1.0, 1.1, 1.2 或 1.3
+	LDAPTLSCipherSuites []string `yaml:"ldap_tls_cipher_suites"` // Go 的 cipher suite 名称，只影响 TLS 1.2 及以下
+	LDAPTLSPins         []string `yaml:"ldap_tls_pins"`          // 证书链中任一证书的 SPKI SHA-256 (base64)
+
+	// OpenTelemetry 链路追踪配置
+	TracingEnabled     bool    `yaml:"tracing_enabled"`
+	TracingEndpoint    string  `yaml:"tracing_otlp_endpoint"` // OTLP/HTTP 地址，为空时使用 OTEL_EXPORTER_OTLP_* 环境变量
+	TracingServiceName string  `yaml:"tracing_service_name"`  // service.name
+	TracingSampleRatio float64 `yaml:"tracing_sample_ratio"`  // 采样率 0-1
+
+	// 连接池配置
+	PoolMaxOpen     int           `yaml:"ldap_pool_max_open"`     // 同时借出的最大连接数
+	PoolMinIdle     int           `yaml:"ldap_pool_min_idle"`     // 后台保持的最少空闲连接数
+	PoolMaxIdle     int           `yaml:"ldap_pool_max_idle"`     // 归还后保留的最多空闲连接数
+	PoolMaxConnAge  time.Duration `yaml:"ldap_pool_max_conn_age"` // 连接最长存活时间，0 表示不限制
+	PoolHealthCheck bool          `yaml:"ldap_pool_health_check"` // 借出前是否做一次 root DSE 探活
+
+	// 多服务器故障转移配置
+	ServerStrategy      string        `yaml:"ldap_server_strategy"`       // failover, round_robin, least_latency
+	ServerMaxFails      int           `yaml:"ldap_server_max_fails"`      // 连续失败多少次后暂时剔除服务器
+	ServerEjectDuration time.Duration `yaml:"ldap_server_eject_duration"` // 剔除时长
+
+	// DNS SRV 服务发现配置
+	LDAPSRVDomain  string        `yaml:"ldap_srv_domain"`  // 例如 "example.com"，为空则不启用
+	LDAPSRVService string        `yaml:"ldap_srv_service"` // SRV 服务名，默认 "ldap" 即 _ldap._tcp.<domain>
+	LDAPSRVRefresh time.Duration `yaml:"ldap_srv_refresh"` // 重新解析间隔
+
+	// JWT 签发配置
+	TokenEnabled     bool              `yaml:"token_enabled"`
+	TokenKeyFiles    []string          `yaml:"token_signing_keys"` // PEM 私钥文件，第一个用于签名，其余仅在 JWKS 中发布
+	TokenKeyRefresh  time.Duration     `yaml:"token_key_refresh"`  // 重新读取私钥文件的间隔，0 表示不重新读取
+	TokenIssuer      string            `yaml:"token_issuer"`       // iss
+	TokenAudience    []string          `yaml:"token_audience"`     // aud
+	TokenTTL         time.Duration     `yaml:"token_ttl"`          // 令牌有效期
+	TokenClaims      map[string]string `yaml:"token_claims"`       // claim 名称 -> LDAP 属性
+	TokenGroupsClaim string            `yaml:"token_groups_claim"` // 组成员关系写入的 claim 名称
+
+	// 组成员关系配置
+	GroupMode       string `yaml:"ldap_group_mode"`      // memberof, member, memberuid, ad_nested；为空则不查询组
+	GroupSearchBase string `yaml:"ldap_group_base"`      // 组搜索基础 DN，为空时使用 UserSearchBase
+	GroupNameAttr   string `yaml:"ldap_group_name_attr"` // 组名属性，默认 cn
+	GroupFormat     string `yaml:"ldap_group_format"`    // 返回格式: name 或 dn
+
+	// 基于组的授权规则，键为客户端应用名，"" 为默认规则
+	AuthzRules map[string]AuthzRule `yaml:"authz_rules"`
+
+	// 用户 bind 时发送 ppolicy 请求控制 (仅 LDAP_DIRECTORY_TYPE=openldap)
+	PasswordPolicyControl bool `yaml:"ldap_ppolicy"`
+
+	// 用户 bind 失败时允许在响应中返回的具体错误码（如 password_expired），其余一律返回 invalid_credentials
+	AuthExposeErrors []string `yaml:"auth_expose_errors"`
+
+	// 是否信任 X-Forwarded-For / X-Real-IP 请求头来确定客户端 IP（仅在反向代理之后启用）
+	TrustProxyHeaders bool `yaml:"trust_proxy_headers"`
+	// 可信反向代理的地址或 CIDR，为空时只信任直接连接的对端
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// 安全审计日志配置，独立于 LogFile
+	AuditSinks          []string      `yaml:"audit_sinks"`            // file, syslog, webhook；为空则不记录
+	AuditFile           string        `yaml:"audit_file"`             // 审计日志文件 (JSON lines)
+	AuditFileMaxSizeMB  int           `yaml:"audit_file_max_size"`    // 单个文件最大大小 (MB)
+	AuditFileMaxBackups int           `yaml:"audit_file_max_backups"` // 保留的旧文件数量
+	AuditFileMaxAgeDays int           `yaml:"audit_file_max_age"`     // 旧文件保留天数
+	AuditFileCompress   bool          `yaml:"audit_file_compress"`    // 是否压缩旧文件
+	AuditSyslogNetwork  string        `yaml:"audit_syslog_network"`   // 为空表示本机 syslog，否则 "udp" / "tcp"
+	AuditSyslogAddr     string        `yaml:"audit_syslog_addr"`      // 远程 syslog 地址
+	AuditSyslogTag      string        `yaml:"audit_syslog_tag"`       // syslog tag
+	AuditWebhookURL     string        `yaml:"audit_webhook_url"`      // 接收审计事件的 URL
+	AuditWebhookTimeout time.Duration `yaml:"audit_webhook_timeout"`  // webhook 请求超时
+
+	// 暴力破解防护配置
+	RateLimitEnabled         bool          `yaml:"rate_limit_enabled"`
+	RateLimitWindow          time.Duration `yaml:"rate_limit_window"`            // 失败次数统计的滑动窗口
+	RateLimitUserMaxFailures int           `yaml:"rate_limit_user_max_failures"` // 窗口内单个用户名失败多少次后本地锁定
+	RateLimitIPMaxFailures   int           `yaml:"rate_limit_ip_max_failures"`   // 窗口内单个 IP 失败多少次后本地锁定
+	RateLimitLockout         time.Duration `yaml:"rate_limit_lockout"`           // 本地锁定时长
+	RateLimitDelay           time.Duration `yaml:"rate_limit_delay"`             // 渐进延迟的基数，每次失败翻倍
+	RateLimitMaxDelay        time.Duration `yaml:"rate_limit_max_delay"`         // 渐进延迟上限
+
+	// 管理接口 API Key，为空则不注册管理接口
+	AdminAPIKeys []string `yaml:"admin_api_keys"`
+
+	// 用户查询接口的调用方认证：API Key 或 mTLS 客户端证书名称 (CN / DNS SAN)，都为空则不注册该接口
+	LookupAPIKeys     []string `yaml:"lookup_api_keys"`
+	LookupClientNames []string `yaml:"lookup_client_names"`
+	LookupAttributes  []string `yaml:"lookup_attributes"` // 查询接口返回的属性，独立于 ReturnAttributes
+
+	// 目录搜索接口 (/v1/users?q=, /v1/groups?q=)
+	SearchUserAttributes  []string      `yaml:"search_user_attributes"`  // 用户搜索匹配的属性
+	SearchGroupAttributes []string      `yaml:"search_group_attributes"` // 组搜索匹配的属性
+	SearchGroupFilter     string        `yaml:"search_group_filter"`     // 组对象过滤器
+	SearchMinQueryLength  int           `yaml:"search_min_query_length"` // 最短查询长度，避免遍历整个目录
+	SearchPageSize        int           `yaml:"search_page_size"`        // 默认每页条数
+	SearchMaxPageSize     int           `yaml:"search_max_page_size"`    // limit 参数上限
+	SearchCursorTTL       time.Duration `yaml:"search_cursor_ttl"`       // 分页游标有效期，期间占用一个连接
+	SearchMaxCursors      int           `yaml:"search_max_cursors"`      // 同时存在的分页游标上限，即搜索专用连接池的大小
+	SearchCallerCursors   int           `yaml:"search_caller_cursors"`   // 每个调用方同时存在的分页游标上限
+
+	// 组成员列表接口 (/v1/groups/{group}/members)
+	GroupMembersMax     int           `yaml:"group_members_max"`     // 返回成员数上限，超过时标记 truncated
+	GroupMembersTimeout time.Duration `yaml:"group_members_timeout"` // 大组及嵌套展开需要比普通请求更长的超时
+
+	// HTTP 服务端超时，0 表示不限制
+	HTTPReadTimeout  time.Duration `yaml:"http_read_timeout"`
+	HTTPWriteTimeout time.Duration `yaml:"http_write_timeout"`
+	HTTPIdleTimeout  time.Duration `yaml:"http_idle_timeout"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"` // 收到退出信号后等待进行中请求完成的时间
+
+	// 配置文件变化的检查间隔，0 表示只在 SIGHUP 时重新加载
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"`
+
+	// 从文件读取的密钥 (Kubernetes / Docker secret 挂载)，内容变化时自动重新加载
+	BindPasswordFile  string `yaml:"ldap_bind_password_file"`
+	AdminAPIKeysFile  string `yaml:"admin_api_keys_file"`
+	LookupAPIKeysFile string `yaml:"lookup_api_keys_file"`
+	BindNTHashFile    string `yaml:"ldap_bind_nt_hash_file"`
+
+	// HashiCorp Vault KV，用于解析 vault://<mount>/<path>#<key> 形式的密钥引用
+	VaultAddr             string        `yaml:"vault_addr"`
+	VaultToken            string        `yaml:"vault_token"`
+	VaultTokenFile        string        `yaml:"vault_token_file"`
+	VaultNamespace        string        `yaml:"vault_namespace"`
+	VaultKVVersion        int           `yaml:"vault_kv_version"`        // KV 引擎版本 1 或 2
+	SecretRefreshInterval time.Duration `yaml:"secret_refresh_interval"` // 重新读取外部密钥的间隔，0 表示只在重新加载时读取
+
+	// 服务端 TLS，设置证书后以 HTTPS 监听；设置客户端 CA 后校验客户端证书 (mTLS)
+	ServiceTLSCert     string `yaml:"service_tls_cert"`
+	ServiceTLSKey      string `yaml:"service_tls_key"`
+	ServiceTLSClientCA string `yaml:"service_tls_client_ca"`
+}
+
+// LoadConfigFromEnv builds the configuration from defaults and environment
+// variables (including a .env file) only.
+func LoadConfigFromEnv() (*Config, error) {
 	// 尝试从 .env 文件加载环境变量（如果存在）
-	// 如果 .env 文件不存在，godotenv.Load() 会返回错误但不会中断程序
-	_ = godotenv.Load()
-
-	c := &Config{
-		ServicePort:        getEnv("SERVICE_PORT", "8080"),
-		LDAPURL:            getEnv("LDAP_URL", "ldap://ldap.example.com:389"),
-		BindDN:             os.Getenv("LDAP_BIND_DN"),
-		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
-		UserSearchBase:     getEnv("LDAP_USER_BASE", "dc=example,dc=com"),
-		UserSearchFilter:   getEnv("LDAP_USER_FILTER", "(uid=%s)"),
-		UserDNAttr:         os.Getenv("LDAP_USER_DN_ATTR"),
+	loadDotEnv()
+
+	c := defaultConfig()
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+	c.normalize()
+	return c, nil
+}
+
+// defaultConfig returns the built-in defaults, the lowest-precedence layer.
+func defaultConfig() *Config {
+	return &Config{
+		ServicePort:        "8080",
+		LDAPURL:            "ldap://ldap.example.com:389",
+		UserSearchBase:     "dc=example,dc=com",
+		UserSearchFilter:   "(uid=%s)",
+		BindMethod:         BindMethodSimple,
+		DirectoryType:      DirectoryOpenLDAP,
 		ReturnAttributes:   []string{"cn", "mail", "uid"},
+		LDAPTLSMinVersion:  "1.2",
 		ConnTimeout:        5 * time.Second,
 		RequestTimeout:     8 * time.Second,
-		UseLDAPS:           getEnv("LDAP_USE_LDAPS", "") == "1",
-		UseStartTLS:        getEnv("LDAP_USE_STARTTLS", "") == "1",
-		InsecureSkipVerify: getEnv("LDAP_INSECURE_SKIP_VERIFY", "") == "1",
-		BasePath:           normalizePath(getEnv("BASE_PATH", "")),
-		LogLevel:           getEnv("LOG_LEVEL", "info"),
-		LogFile:            getEnv("LOG_FILE", "app.log"),
+		LogLevel:           "info",
+		LogFile:            "app.log",
+		MetricsEnabled:     true,
+		TracingServiceName: "ldap-microservice",
+		TracingSampleRatio: 1.0,
+		PoolMaxOpen:        10,
+		PoolMaxIdle:        5,
+		PoolMaxConnAge:     30 * time.Minute,
+		PoolHealthCheck:    true,
+
+		ServerStrategy:      StrategyFailover,
+		ServerMaxFails:      3,
+		ServerEjectDuration: 30 * time.Second,
+
+		LDAPSRVService: "ldap",
+		LDAPSRVRefresh: 5 * time.Minute,
+
+		TokenKeyRefresh:  5 * time.Minute,
+		TokenTTL:         time.Hour,
+		TokenClaims:      map[string]string{"name": "cn", "email": "mail"},
+		TokenGroupsClaim: "groups",
+
+		GroupNameAttr: "cn",
+		GroupFormat:   GroupFormatName,
+
+		AuthzRules: map[string]AuthzRule{},
+
+		PasswordPolicyControl: true,
+
+		AuthExposeErrors: []string{string(ErrPasswordExpired), string(ErrPasswordMustChange)},
+
+		AuditFile:           "audit.log",
+		AuditFileMaxSizeMB:  100,
+		AuditFileMaxBackups: 10,
+		AuditFileMaxAgeDays: 90,
+		AuditFileCompress:   true,
+		AuditSyslogTag:      "ldap-microservice",
+		AuditWebhookTimeout: 5 * time.Second,
+
+		RateLimitEnabled:         true,
+		RateLimitWindow:          15 * time.Minute,
+		RateLimitUserMaxFailures: 5,
+		RateLimitIPMaxFailures:   50,
+		RateLimitLockout:         15 * time.Minute,
+		RateLimitDelay:           500 * time.Millisecond,
+		RateLimitMaxDelay:        5 * time.Second,
+
+		LookupAttributes: []string{"cn", "mail", "uid"},
+
+		SearchUserAttributes:  []string{"cn", "mail", "displayName"},
+		SearchGroupAttributes: []string{"cn", "description"},
+		SearchGroupFilter:     "(|(objectClass=groupOfNames)(objectClass=groupOfUniqueNames)(objectClass=posixGroup)(objectClass=group))",
+		SearchMinQueryLength:  2,
+		SearchPageSize:        25,
+		SearchMaxPageSize:     200,
+		SearchCursorTTL:       2 * time.Minute,
+		SearchMaxCursors:      4,
+		SearchCallerCursors:   2,
+
+		GroupMembersMax:     10000,
+		GroupMembersTimeout: time.Minute,
+
+		HTTPReadTimeout:  10 * time.Second,
+		HTTPWriteTimeout: 15 * time.Second,
+		HTTPIdleTimeout:  60 * time.Second,
+		ShutdownTimeout:  10 * time.Second,
+
+		ConfigWatchInterval: 10 * time.Second,
+
+		VaultKVVersion:        2,
+		SecretRefreshInterval: 5 * time.Minute,
 	}
-	return c
+}
+
+// applyEnv overrides c with every environment variable that is set; unset
+// variables keep the value from the defaults or the config file. Numbers
+// and durations that do not parse are reported as an ErrInvalidConfig
+// LDAPError rather than falling back to the previous value.
+func applyEnv(c *Config) error {
+	var errs ConfigErrors
+	c.ServicePort = getEnv("SERVICE_PORT", c.ServicePort)
+	c.LDAPURL = getEnv("LDAP_URL", c.LDAPURL)
+	c.LDAPURLs = getEnvList("LDAP_URLS", c.LDAPURLs)
+	c.BindDN = getEnv("LDAP_BIND_DN", c.BindDN)
+	c.BindPassword = getEnv("LDAP_BIND_PASSWORD", c.BindPassword)
+	c.BindMethod = getEnv("LDAP_BIND_METHOD", c.BindMethod)
+	c.BindNTHash = getEnv("LDAP_BIND_NT_HASH", c.BindNTHash)
+	c.UserNTLMDomains = getEnvList("LDAP_USER_NTLM_DOMAINS", c.UserNTLMDomains)
+	if v := os.Getenv("LDAP_USER_NTLM_BASES"); v != "" {
+		c.UserNTLMBases = splitMapSep(v, ";")
+	}
+	c.UserSearchBase = getEnv("LDAP_USER_BASE", c.UserSearchBase)
+	c.UserSearchFilter = getEnv("LDAP_USER_FILTER", c.UserSearchFilter)
+	c.UserDNAttr = getEnv("LDAP_USER_DN_ATTR", c.UserDNAttr)
+	c.DirectoryType = getEnv("LDAP_DIRECTORY_TYPE", c.DirectoryType)
+	c.ReturnAttributes = getEnvList("LDAP_RETURN_ATTRIBUTES", c.ReturnAttributes)
+	c.ConnTimeout = errs.getEnvDuration("LDAP_CONN_TIMEOUT", c.ConnTimeout)
+	c.RequestTimeout = errs.getEnvDuration("LDAP_REQUEST_TIMEOUT", c.RequestTimeout)
+	c.UseLDAPS = getEnvBool("LDAP_USE_LDAPS", c.UseLDAPS)
+	c.UseStartTLS = getEnvBool("LDAP_USE_STARTTLS", c.UseStartTLS)
+	c.InsecureSkipVerify = getEnvBool("LDAP_INSECURE_SKIP_VERIFY", c.InsecureSkipVerify)
+	c.LDAPTLSCAFile = getEnv("LDAP_TLS_CA_FILE", c.LDAPTLSCAFile)
+	c.LDAPTLSCert = getEnv("LDAP_TLS_CERT", c.LDAPTLSCert)
+	c.LDAPTLSKey = getEnv("LDAP_TLS_KEY", c.LDAPTLSKey)
+	c.LDAPTLSServerName = getEnv("LDAP_TLS_SERVER_NAME", c.LDAPTLSServerName)
+	c.LDAPTLSMinVersion = getEnv("LDAP_TLS_MIN_VERSION", c.LDAPTLSMinVersion)
+	c.LDAPTLSCipherSuites = getEnvList("LDAP_TLS_CIPHER_SUITES", c.LDAPTLSCipherSuites)
+	c.LDAPTLSPins = getEnvList("LDAP_TLS_PINS", c.LDAPTLSPins)
+	c.BasePath = getEnv("BASE_PATH", c.BasePath)
+	c.LogLevel = getEnv("LOG_LEVEL", c.LogLevel)
+	c.LogFile = getEnv("LOG_FILE", c.LogFile)
+	c.MetricsEnabled = getEnvBool("METRICS_ENABLED", c.MetricsEnabled)
+	c.TracingEnabled = getEnvBool("TRACING_ENABLED", c.TracingEnabled)
+	c.TracingEndpoint = getEnv("TRACING_OTLP_ENDPOINT", c.TracingEndpoint)
+	c.TracingServiceName = getEnv("TRACING_SERVICE_NAME", c.TracingServiceName)
+	c.TracingSampleRatio = errs.getEnvFloat("TRACING_SAMPLE_RATIO", c.TracingSampleRatio)
+	c.PoolMaxOpen = errs.getEnvInt("LDAP_POOL_MAX_OPEN", c.PoolMaxOpen)
+	c.PoolMinIdle = errs.getEnvInt("LDAP_POOL_MIN_IDLE", c.PoolMinIdle)
+	c.PoolMaxIdle = errs.getEnvInt("LDAP_POOL_MAX_IDLE", c.PoolMaxIdle)
+	c.PoolMaxConnAge = errs.getEnvDuration("LDAP_POOL_MAX_CONN_AGE", c.PoolMaxConnAge)
+	c.PoolHealthCheck = getEnvBool("LDAP_POOL_HEALTH_CHECK", c.PoolHealthCheck)
+
+	c.ServerStrategy = getEnv("LDAP_SERVER_STRATEGY", c.ServerStrategy)
+	c.ServerMaxFails = errs.getEnvInt("LDAP_SERVER_MAX_FAILS", c.ServerMaxFails)
+	c.ServerEjectDuration = errs.getEnvDuration("LDAP_SERVER_EJECT_DURATION", c.ServerEjectDuration)
+
+	c.LDAPSRVDomain = getEnv("LDAP_SRV_DOMAIN", c.LDAPSRVDomain)
+	c.LDAPSRVService = getEnv("LDAP_SRV_SERVICE", c.LDAPSRVService)
+	c.LDAPSRVRefresh = errs.getEnvDuration("LDAP_SRV_REFRESH", c.LDAPSRVRefresh)
+
+	c.TokenEnabled = getEnvBool("TOKEN_ENABLED", c.TokenEnabled)
+	c.TokenKeyFiles = getEnvList("TOKEN_SIGNING_KEYS", c.TokenKeyFiles)
+	c.TokenKeyRefresh = errs.getEnvDuration("TOKEN_KEY_REFRESH", c.TokenKeyRefresh)
+	c.TokenIssuer = getEnv("TOKEN_ISSUER", c.TokenIssuer)
+	c.TokenAudience = getEnvList("TOKEN_AUDIENCE", c.TokenAudience)
+	c.TokenTTL = errs.getEnvDuration("TOKEN_TTL", c.TokenTTL)
+	if v := os.Getenv("TOKEN_CLAIMS"); v != "" {
+		c.TokenClaims = splitMap(v)
+	}
+	c.TokenGroupsClaim = getEnv("TOKEN_GROUPS_CLAIM", c.TokenGroupsClaim)
+
+	c.GroupMode = getEnv("LDAP_GROUP_MODE", c.GroupMode)
+	c.GroupSearchBase = getEnv("LDAP_GROUP_BASE", c.GroupSearchBase)
+	c.GroupNameAttr = getEnv("LDAP_GROUP_NAME_ATTR", c.GroupNameAttr)
+	c.GroupFormat = getEnv("LDAP_GROUP_FORMAT", c.GroupFormat)
+
+	// 环境变量中的规则按客户端覆盖文件中的规则，只替换设置了的 allow / deny 列表
+	if c.AuthzRules == nil {
+		c.AuthzRules = map[string]AuthzRule{}
+	}
+	for client, r := range loadAuthzRulesFromEnv() {
+		cur := c.AuthzRules[client]
+		if r.Allow != nil {
+			cur.Allow = r.Allow
+		}
+		if r.Deny != nil {
+			cur.Deny = r.Deny
+		}
+		c.AuthzRules[client] = cur
+	}
+
+	c.PasswordPolicyControl = getEnvBool("LDAP_PPOLICY", c.PasswordPolicyControl)
+
+	c.AuthExposeErrors = getEnvList("AUTH_EXPOSE_ERRORS", c.AuthExposeErrors)
+
+	c.TrustProxyHeaders = getEnvBool("TRUST_PROXY_HEADERS", c.TrustProxyHeaders)
+	c.TrustedProxies = getEnvList("TRUSTED_PROXIES", c.TrustedProxies)
+
+	c.AuditSinks = getEnvList("AUDIT_SINKS", c.AuditSinks)
+	c.AuditFile = getEnv("AUDIT_FILE", c.AuditFile)
+	c.AuditFileMaxSizeMB = errs.getEnvInt("AUDIT_FILE_MAX_SIZE", c.AuditFileMaxSizeMB)
+	c.AuditFileMaxBackups = errs.getEnvInt("AUDIT_FILE_MAX_BACKUPS", c.AuditFileMaxBackups)
+	c.AuditFileMaxAgeDays = errs.getEnvInt("AUDIT_FILE_MAX_AGE", c.AuditFileMaxAgeDays)
+	c.AuditFileCompress = getEnvBool("AUDIT_FILE_COMPRESS", c.AuditFileCompress)
+	c.AuditSyslogNetwork = getEnv("AUDIT_SYSLOG_NETWORK", c.AuditSyslogNetwork)
+	c.AuditSyslogAddr = getEnv("AUDIT_SYSLOG_ADDR", c.AuditSyslogAddr)
+	c.AuditSyslogTag = getEnv("AUDIT_SYSLOG_TAG", c.AuditSyslogTag)
+	c.AuditWebhookURL = getEnv("AUDIT_WEBHOOK_URL", c.AuditWebhookURL)
+	c.AuditWebhookTimeout = errs.getEnvDuration("AUDIT_WEBHOOK_TIMEOUT", c.AuditWebhookTimeout)
+
+	c.RateLimitEnabled = getEnvBool("RATE_LIMIT_ENABLED", c.RateLimitEnabled)
+	c.RateLimitWindow = errs.getEnvDuration("RATE_LIMIT_WINDOW", c.RateLimitWindow)
+	c.RateLimitUserMaxFailures = errs.getEnvInt("RATE_LIMIT_USER_MAX_FAILURES", c.RateLimitUserMaxFailures)
+	c.RateLimitIPMaxFailures = errs.getEnvInt("RATE_LIMIT_IP_MAX_FAILURES", c.RateLimitIPMaxFailures)
+	c.RateLimitLockout = errs.getEnvDuration("RATE_LIMIT_LOCKOUT", c.RateLimitLockout)
+	c.RateLimitDelay = errs.getEnvDuration("RATE_LIMIT_DELAY", c.RateLimitDelay)
+	c.RateLimitMaxDelay = errs.getEnvDuration("RATE_LIMIT_MAX_DELAY", c.RateLimitMaxDelay)
+
+	c.AdminAPIKeys = getEnvList("ADMIN_API_KEYS", c.AdminAPIKeys)
+
+	c.LookupAPIKeys = getEnvList("LOOKUP_API_KEYS", c.LookupAPIKeys)
+	c.LookupClientNames = getEnvList("LOOKUP_CLIENT_NAMES", c.LookupClientNames)
+	c.LookupAttributes = getEnvList("LOOKUP_ATTRIBUTES", c.LookupAttributes)
+
+	c.SearchUserAttributes = getEnvList("SEARCH_USER_ATTRIBUTES", c.SearchUserAttributes)
+	c.SearchGroupAttributes = getEnvList("SEARCH_GROUP_ATTRIBUTES", c.SearchGroupAttributes)
+	c.SearchGroupFilter = getEnv("SEARCH_GROUP_FILTER", c.SearchGroupFilter)
+	c.SearchMinQueryLength = errs.getEnvInt("SEARCH_MIN_QUERY_LENGTH", c.SearchMinQueryLength)
+	c.SearchPageSize = errs.getEnvInt("SEARCH_PAGE_SIZE", c.SearchPageSize)
+	c.SearchMaxPageSize = errs.getEnvInt("SEARCH_MAX_PAGE_SIZE", c.SearchMaxPageSize)
+	c.SearchCursorTTL = errs.getEnvDuration("SEARCH_CURSOR_TTL", c.SearchCursorTTL)
+	c.SearchMaxCursors = errs.getEnvInt("SEARCH_MAX_CURSORS", c.SearchMaxCursors)
+	c.SearchCallerCursors = errs.getEnvInt("SEARCH_CALLER_CURSORS", c.SearchCallerCursors)
+
+	c.GroupMembersMax = errs.getEnvInt("GROUP_MEMBERS_MAX", c.GroupMembersMax)
+	c.GroupMembersTimeout = errs.getEnvDuration("GROUP_MEMBERS_TIMEOUT", c.GroupMembersTimeout)
+
+	c.HTTPReadTimeout = errs.getEnvDuration("HTTP_READ_TIMEOUT", c.HTTPReadTimeout)
+	c.HTTPWriteTimeout = errs.getEnvDuration("HTTP_WRITE_TIMEOUT", c.HTTPWriteTimeout)
+	c.HTTPIdleTimeout = errs.getEnvDuration("HTTP_IDLE_TIMEOUT", c.HTTPIdleTimeout)
+	c.ShutdownTimeout = errs.getEnvDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+
+	c.ConfigWatchInterval = errs.getEnvDuration("CONFIG_WATCH_INTERVAL", c.ConfigWatchInterval)
+
+	c.BindPasswordFile = getEnv("LDAP_BIND_PASSWORD_FILE", c.BindPasswordFile)
+	c.AdminAPIKeysFile = getEnv("ADMIN_API_KEYS_FILE", c.AdminAPIKeysFile)
+	c.LookupAPIKeysFile = getEnv("LOOKUP_API_KEYS_FILE", c.LookupAPIKeysFile)
+	c.BindNTHashFile = getEnv("LDAP_BIND_NT_HASH_FILE", c.BindNTHashFile)
+
+	c.VaultAddr = getEnv("VAULT_ADDR", c.VaultAddr)
+	c.VaultToken = getEnv("VAULT_TOKEN", c.VaultToken)
+	c.VaultTokenFile = getEnv("VAULT_TOKEN_FILE", c.VaultTokenFile)
+	c.VaultNamespace = getEnv("VAULT_NAMESPACE", c.VaultNamespace)
+	c.VaultKVVersion = errs.getEnvInt("VAULT_KV_VERSION", c.VaultKVVersion)
+	c.SecretRefreshInterval = errs.getEnvDuration("SECRET_REFRESH_INTERVAL", c.SecretRefreshInterval)
+
+	c.ServiceTLSCert = getEnv("SERVICE_TLS_CERT", c.ServiceTLSCert)
+	c.ServiceTLSKey = getEnv("SERVICE_TLS_KEY", c.ServiceTLSKey)
+	c.ServiceTLSClientCA = getEnv("SERVICE_TLS_CLIENT_CA", c.ServiceTLSClientCA)
+	return errs.err()
+}
+
+// normalize canonicalises values that may come from either source.
+func (c *Config) normalize() {
+	c.DirectoryType = strings.ToLower(c.DirectoryType)
+	c.BindMethod = strings.ToLower(c.BindMethod)
+	c.ServerStrategy = strings.ToLower(c.ServerStrategy)
+	c.GroupMode = strings.ToLower(c.GroupMode)
+	c.GroupFormat = strings.ToLower(c.GroupFormat)
+	c.BasePath = normalizePath(c.BasePath)
 }
 
 func getEnv(k, def string) string {
@@ -60,6 +447,143 @@ func getEnv(k, def string) string {
 	return def
 }
 
+// getEnvBool 读取 "1" 表示开启的布尔环境变量，未设置时返回默认值
+func getEnvBool(k string, def bool) bool {
+	if v := os.Getenv(k); v != "" {
+		return v == "1"
+	}
+	return def
+}
+
+// getEnvList 读取逗号分隔的列表环境变量，未设置时返回默认值
+func getEnvList(k string, def []string) []string {
+	if v := os.Getenv(k); v != "" {
+		return splitList(v)
+	}
+	return def
+}
+
+// getEnvInt 读取整数环境变量，未设置时返回默认值，无法解析时记录到 e
+func (e *ConfigErrors) getEnvInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			*e = append(*e, fmt.Sprintf("%s: %q is not an integer", k, v))
+			return def
+		}
+		return n
+	}
+	return def
+}
+
+// getEnvFloat 读取浮点数环境变量，未设置时返回默认值，无法解析时记录到 e
+func (e *ConfigErrors) getEnvFloat(k string, def float64) float64 {
+	if v := os.Getenv(k); v != "" {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			*e = append(*e, fmt.Sprintf("%s: %q is not a number", k, v))
+			return def
+		}
+		return f
+	}
+	return def
+}
+
+// getEnvDuration 读取时长环境变量，支持 time.ParseDuration 格式（如 "30m"、"500ms"）或纯数字秒数；
+// 无法解析时记录到 e
+func (e *ConfigErrors) getEnvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		d, err := parseDuration(v)
+		if err != nil {
+			*e = append(*e, fmt.Sprintf("%s: %q is not a duration, use e.g. 30s or 5m", k, v))
+			return def
+		}
+		return d
+	}
+	return def
+}
+
+// parseDuration parses a Go duration string, or a bare number as seconds
+// ("5", "0.5").
+func parseDuration(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
+
+// splitList 将逗号分隔的字符串拆分为去除空白后的非空项
+func splitList(v string) []string {
+	return splitListSep(v, ",")
+}
+
+// splitListSep 与 splitList 相同，但使用指定的分隔符（例如包含逗号的 DN 列表使用 ";"）
+func splitListSep(v, sep string) []string {
+	var out []string
+	for _, item := range strings.Split(v, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// splitMap 解析 "k1=v1,k2=v2" 形式的映射，忽略格式不正确的项
+func splitMap(v string) map[string]string {
+	return splitMapSep(v, ",")
+}
+
+// splitMapSep 与 splitMap 相同，但使用指定的分隔符（例如值为 DN 时使用 ";"）
+func splitMapSep(v, sep string) map[string]string {
+	out := map[string]string{}
+	for _, item := range splitListSep(v, sep) {
+		k, val, ok := strings.Cut(item, "=")
+		k, val = strings.TrimSpace(k), strings.TrimSpace(val)
+		if ok && k != "" && val != "" {
+			out[k] = val
+		}
+	}
+	return out
+}
+
+// UserAttributes returns every attribute that has to be read from the user
+// entry: ReturnAttributes plus attributes referenced by token claims.
+func (c *Config) UserAttributes() []string {
+	attrs := append([]string{}, c.ReturnAttributes...)
+	seen := map[string]bool{}
+	for _, a := range attrs {
+		seen[strings.ToLower(a)] = true
+	}
+	if c.TokenEnabled {
+		for _, a := range c.TokenClaims {
+			if !seen[strings.ToLower(a)] {
+				seen[strings.ToLower(a)] = true
+				attrs = append(attrs, a)
+			}
+		}
+	}
+	return attrs
+}
+
+// GroupBase returns the base DN for group searches.
+func (c *Config) GroupBase() string {
+	if c.GroupSearchBase != "" {
+		return c.GroupSearchBase
+	}
+	return c.UserSearchBase
+}
+
+// ServerURLs returns the LDAP servers to use, in configured order.
+// LDAPURLs takes precedence; otherwise LDAPURL may itself be a
+// comma-separated list.
+func (c *Config) ServerURLs() []string {
+	if len(c.LDAPURLs) > 0 {
+		return c.LDAPURLs
+	}
+	return splitList(c.LDAPURL)
+}
+
 // normalizePath 规范化 URL 路径前缀
 // - 移除末尾的 /
 // - 确保开头有 /（如果路径非空）
@@ -82,12 +606,48 @@ func (c *Config) ToMap() map[string]any {
 	return map[string]any{
 		"ServicePort":      c.ServicePort,
 		"LDAPURL":          c.LDAPURL,
+		"LDAPURLs":         c.ServerURLs(),
 		"UserSearchBase":   c.UserSearchBase,
 		"UserSearchFilter": c.UserSearchFilter,
+		"DirectoryType":    c.DirectoryType,
+		"BindMethod":       c.BindMethod,
+		"UserNTLMDomains":  c.UserNTLMDomains,
+		"UserNTLMBases":    c.UserNTLMBases,
 		"UseLDAPS":         c.UseLDAPS,
 		"UseStartTLS":      c.UseStartTLS,
+		"LDAPTLSCAFile":    c.LDAPTLSCAFile,
+		"LDAPTLSCert":      c.LDAPTLSCert != "",
+		"LDAPTLSPins":      len(c.LDAPTLSPins) > 0,
 		"BasePath":         c.BasePath,
 		"LogLevel":         c.LogLevel,
 		"LogFile":          c.LogFile,
+		"MetricsEnabled":   c.MetricsEnabled,
+		"TracingEnabled":   c.TracingEnabled,
+		"PoolMaxOpen":      c.PoolMaxOpen,
+		"PoolMinIdle":      c.PoolMinIdle,
+		"PoolMaxIdle":      c.PoolMaxIdle,
+		"PoolMaxConnAge":   c.PoolMaxConnAge.String(),
+		"PoolHealthCheck":  c.PoolHealthCheck,
+		"ServerStrategy":   c.ServerStrategy,
+		"LDAPSRVDomain":    c.LDAPSRVDomain,
+		"TokenEnabled":     c.TokenEnabled,
+		"TokenIssuer":      c.TokenIssuer,
+		"GroupMode":        c.GroupMode,
+		"GroupSearchBase":  c.GroupBase(),
+		"AuthzRules":       c.AuthzRules,
+		"AuthExposeErrors": c.AuthExposeErrors,
+		"PasswordPolicy":   c.PasswordPolicyControl,
+		"AuditSinks":       c.AuditSinks,
+		"AuditFile":        c.AuditFile,
+		"RateLimitEnabled": c.RateLimitEnabled,
+		"AdminEnabled":     len(c.AdminAPIKeys) > 0,
+		"LookupEnabled":    c.LookupEnabled(),
+		"LookupAttributes": c.LookupAttributes,
+		"ConnTimeout":      c.ConnTimeout.String(),
+		"RequestTimeout":   c.RequestTimeout.String(),
+		"ReturnAttributes": c.ReturnAttributes,
+		"ServiceTLS":       c.ServiceTLSCert != "",
+		"ServiceMTLS":      c.ServiceTLSClientCA != "",
+		"VaultAddr":        c.VaultAddr,
 	}
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,7 +39,10 @@ func TestLoadConfigFromEnv(t *testing.T) {
 	}
 
 	// Test default values
-	cfg := LoadConfigFromEnv()
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
 	if cfg.ServicePort != "8080" {
 		t.Errorf("expected ServicePort=8080, got %s", cfg.ServicePort)
 	}
@@ -86,7 +91,10 @@ func TestLoadConfigFromEnvWithOverrides(t *testing.T) {
 	os.Setenv("LDAP_USE_LDAPS", "1")
 	os.Setenv("LDAP_INSECURE_SKIP_VERIFY", "1")
 
-	cfg := LoadConfigFromEnv()
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
 	if cfg.ServicePort != "9090" {
 		t.Errorf("expected ServicePort=9090, got %s", cfg.ServicePort)
 	}
@@ -103,12 +111,12 @@ func TestLoadConfigFromEnvWithOverrides(t *testing.T) {
 
 func TestConfigToMap(t *testing.T) {
 	cfg := &Config{
-		ServicePort:       "8080",
-		LDAPURL:           "ldap://localhost:389",
-		UserSearchBase:    "dc=example,dc=com",
-		UserSearchFilter:  "(uid=%s)",
-		UseLDAPS:          false,
-		UseStartTLS:       false,
+		ServicePort:      "8080",
+		LDAPURL:          "ldap://localhost:389",
+		UserSearchBase:   "dc=example,dc=com",
+		UserSearchFilter: "(uid=%s)",
+		UseLDAPS:         false,
+		UseStartTLS:      false,
 	}
 
 	m := cfg.ToMap()
@@ -146,3 +154,90 @@ func TestGetEnv(t *testing.T) {
 	}
 }
 
+func TestParseDuration(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"5s":    5 * time.Second,
+		"500ms": 500 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+		"7":     7 * time.Second,
+		" 0.5 ": 500 * time.Millisecond,
+		"0":     0,
+	} {
+		got, err := parseDuration(in)
+		if err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseDuration("5 seconds"); err == nil {
+		t.Error("expected error for 5 seconds")
+	}
+}
+
+func TestLoadConfigFromEnvTimeoutsAndAttributes(t *testing.T) {
+	t.Setenv("LDAP_CONN_TIMEOUT", "3")
+	t.Setenv("LDAP_REQUEST_TIMEOUT", "2500ms")
+	t.Setenv("LDAP_RETURN_ATTRIBUTES", " cn, mail ,displayName,, ")
+	t.Setenv("HTTP_READ_TIMEOUT", "20s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "30")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "2m")
+	t.Setenv("SHUTDOWN_TIMEOUT", "45s")
+
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
+	checks := map[string][2]time.Duration{
+		"ConnTimeout":      {cfg.ConnTimeout, 3 * time.Second},
+		"RequestTimeout":   {cfg.RequestTimeout, 2500 * time.Millisecond},
+		"HTTPReadTimeout":  {cfg.HTTPReadTimeout, 20 * time.Second},
+		"HTTPWriteTimeout": {cfg.HTTPWriteTimeout, 30 * time.Second},
+		"HTTPIdleTimeout":  {cfg.HTTPIdleTimeout, 2 * time.Minute},
+		"ShutdownTimeout":  {cfg.ShutdownTimeout, 45 * time.Second},
+	}
+	for name, c := range checks {
+		if c[0] != c[1] {
+			t.Errorf("%s = %v, want %v", name, c[0], c[1])
+		}
+	}
+	if got := strings.Join(cfg.ReturnAttributes, ","); got != "cn,mail,displayName" {
+		t.Errorf("ReturnAttributes = %s", got)
+	}
+}
+
+func TestLoadConfigFromEnvTimeoutDefaults(t *testing.T) {
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
+	if cfg.ConnTimeout != 5*time.Second {
+		t.Errorf("ConnTimeout default = %v", cfg.ConnTimeout)
+	}
+	if cfg.HTTPReadTimeout != 10*time.Second || cfg.HTTPWriteTimeout != 15*time.Second || cfg.HTTPIdleTimeout != 60*time.Second || cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("HTTP defaults = %v/%v/%v, shutdown %v", cfg.HTTPReadTimeout, cfg.HTTPWriteTimeout, cfg.HTTPIdleTimeout, cfg.ShutdownTimeout)
+	}
+	if got := strings.Join(cfg.ReturnAttributes, ","); got != "cn,mail,uid" {
+		t.Errorf("ReturnAttributes = %s", got)
+	}
+}
+
+func TestLoadConfigFromEnvUnparsable(t *testing.T) {
+	t.Setenv("LDAP_CONN_TIMEOUT", "soon")
+	t.Setenv("LDAP_POOL_MAX_OPEN", "ten")
+	t.Setenv(ConfigFileEnv, "")
+	_, err := LoadConfigFromEnv()
+	if GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("problems = %v", err)
+	}
+	if !strings.HasPrefix(problems[0], "LDAP_CONN_TIMEOUT: ") || !strings.HasPrefix(problems[1], "LDAP_POOL_MAX_OPEN: ") {
+		t.Errorf("problems = %q", problems)
+	}
+
+	// check-config and reload load through LoadConfig and reject it the same way
+	if _, err := LoadConfig(""); GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Errorf("LoadConfig: expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when -config is not given.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// authzDefaultKey is the authz_rules key of the rule for requests without a
+// matching client rule, stored under "" in Config.AuthzRules.
+const authzDefaultKey = "default"
+
+// LoadConfig builds the configuration in three layers, each overriding the
+// previous one: built-in defaults, the YAML or TOML file at path (or
+// $CONFIG_FILE when path is empty), and environment variables including
+// .env. Secrets are then read from their *_FILE settings and secret
+// providers.
+func LoadConfig(path string) (*Config, error) {
+	path = configFilePath(path)
+
+	c := defaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, c); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(c); err != nil {
+		return nil, err
+	}
+	c.normalize()
+	return c, nil
+}
+
+// configFilePath loads .env and returns path, or $CONFIG_FILE when path is
+// empty. The result is "" when no config file is used.
+func configFilePath(path string) string {
+	loadDotEnv()
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	return path
+}
+
+// dotEnvKeys holds the variables that loadDotEnv set from .env, as opposed
+// to the process environment.
+var (
+	dotEnvMu   sync.Mutex
+	dotEnvKeys = map[string]bool{}
+)
+
+// loadDotEnv applies .env from the working directory to the environment.
+// As with godotenv.Load, variables set by the process environment win.
+// Unlike it, variables that came from .env are updated on every call and
+// unset when .env no longer has them, so a reload picks up edits. A .env
+// that fails to parse leaves the environment as it is.
+func loadDotEnv() {
+	env, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn().Err(err).Msg("failed to read .env, keeping the current environment")
+		return
+	}
+	dotEnvMu.Lock()
+	defer dotEnvMu.Unlock()
+	for k := range dotEnvKeys {
+		if _, ok := env[k]; !ok {
+			os.Unsetenv(k)
+			delete(dotEnvKeys, k)
+		}
+	}
+	for k, v := range env {
+		if _, set := os.LookupEnv(k); set && !dotEnvKeys[k] {
+			continue
+		}
+		os.Setenv(k, v)
+		dotEnvKeys[k] = true
+	}
+}
+
+// loadConfigFile decodes the file over c, leaving keys the file doesn't set
+// at their current value. The format is chosen by extension. Unknown keys
+// are rejected so that typos don't silently fall back to defaults.
+func loadConfigFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	case ".toml":
+		// TOML 先解析为通用结构再转成 YAML，两种格式共用 Config 上的 yaml 标签
+		var doc map[string]any
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .yaml, .yml or .toml", path, ext)
+	}
+
+	// 映射类型的字段由文件整体替换，而不是与默认值合并
+	claims := c.TokenClaims
+	c.TokenClaims = nil
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	if c.TokenClaims == nil {
+		c.TokenClaims = claims
+	}
+	rules := map[string]AuthzRule{}
+	for client, r := range c.AuthzRules {
+		if client == authzDefaultKey {
+			client = ""
+		}
+		rules[authzClientKey(client)] = r
+	}
+	c.AuthzRules = rules
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+service_port: "9000"
+base_path: ldap/
+ldap_urls:
+  - ldaps://dc1.example.com:636
+  - ldaps://dc2.example.com:636
+ldap_directory_type: AD
+ldap_use_ldaps: true
+ldap_pool_max_conn_age: 10m
+token_claims:
+  upn: userPrincipalName
+authz_rules:
+  default:
+    allow_groups: [staff]
+  hr-portal:
+    allow_groups: ["cn=hr,ou=groups,dc=example,dc=com"]
+    deny_groups: [contractors]
+`)
+	t.Setenv("SERVICE_PORT", "9100")
+	t.Setenv("AUTHZ_CLIENT_HR_PORTAL_DENY_GROUPS", "interns")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	// env > file > defaults
+	if cfg.ServicePort != "9100" {
+		t.Errorf("ServicePort = %s, want env value 9100", cfg.ServicePort)
+	}
+	if !slices.Equal(cfg.ServerURLs(), []string{"ldaps://dc1.example.com:636", "ldaps://dc2.example.com:636"}) {
+		t.Errorf("ServerURLs = %v", cfg.ServerURLs())
+	}
+	if cfg.DirectoryType != DirectoryAD || cfg.BasePath != "/ldap" || !cfg.UseLDAPS {
+		t.Errorf("DirectoryType = %s, BasePath = %s, UseLDAPS = %v", cfg.DirectoryType, cfg.BasePath, cfg.UseLDAPS)
+	}
+	if cfg.PoolMaxConnAge != 10*time.Minute || cfg.PoolMaxOpen != 10 {
+		t.Errorf("PoolMaxConnAge = %v, PoolMaxOpen = %d", cfg.PoolMaxConnAge, cfg.PoolMaxOpen)
+	}
+	if len(cfg.TokenClaims) != 1 || cfg.TokenClaims["upn"] != "userPrincipalName" {
+		t.Errorf("TokenClaims = %v, want the file's map only", cfg.TokenClaims)
+	}
+
+	if r := cfg.AuthzRuleFor(""); !slices.Equal(r.Allow, []string{"staff"}) {
+		t.Errorf("default rule = %+v", r)
+	}
+	r := cfg.AuthzRuleFor("hr-portal")
+	if !slices.Equal(r.Allow, []string{"cn=hr,ou=groups,dc=example,dc=com"}) || !slices.Equal(r.Deny, []string{"interns"}) {
+		t.Errorf("hr-portal rule = %+v", r)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+ldap_user_filter = "(sAMAccountName=%s)"
+lookup_attributes = ["cn", "mail"]
+rate_limit_enabled = false
+rate_limit_window = "5m"
+
+[authz_rules.crm]
+deny_groups = ["disabled"]
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.UserSearchFilter != "(sAMAccountName=%s)" || cfg.RateLimitEnabled || cfg.RateLimitWindow != 5*time.Minute {
+		t.Errorf("UserSearchFilter = %s, RateLimitEnabled = %v, RateLimitWindow = %v", cfg.UserSearchFilter, cfg.RateLimitEnabled, cfg.RateLimitWindow)
+	}
+	if !slices.Equal(cfg.LookupAttributes, []string{"cn", "mail"}) {
+		t.Errorf("LookupAttributes = %v", cfg.LookupAttributes)
+	}
+	if r := cfg.AuthzRuleFor("crm"); !slices.Equal(r.Deny, []string{"disabled"}) {
+		t.Errorf("crm rule = %+v", r)
+	}
+	if cfg.TokenClaims["email"] != "mail" {
+		t.Errorf("default TokenClaims lost: %v", cfg.TokenClaims)
+	}
+}
+
+func TestLoadConfigFromEnvVariable(t *testing.T) {
+	t.Setenv(ConfigFileEnv, writeConfigFile(t, "c.yml", "ldap_user_base: ou=people,dc=example,dc=org\n"))
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.UserSearchBase != "ou=people,dc=example,dc=org" {
+		t.Errorf("UserSearchBase = %s", cfg.UserSearchBase)
+	}
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("DOTENV_TEST_PROCESS", "process")
+	t.Cleanup(func() {
+		os.Remove(".env")
+		loadDotEnv()
+	})
+
+	rewriteConfigFile(t, ".env", "DOTENV_TEST_EDITED=1\nDOTENV_TEST_PROCESS=dotenv\nDOTENV_TEST_REMOVED=x\n")
+	loadDotEnv()
+	if got := os.Getenv("DOTENV_TEST_EDITED"); got != "1" {
+		t.Fatalf("DOTENV_TEST_EDITED = %q", got)
+	}
+
+	// a reload sees edits to .env, but the process environment still wins
+	rewriteConfigFile(t, ".env", "DOTENV_TEST_EDITED=2\nDOTENV_TEST_PROCESS=dotenv\n")
+	loadDotEnv()
+	if got := os.Getenv("DOTENV_TEST_EDITED"); got != "2" {
+		t.Errorf("edited DOTENV_TEST_EDITED = %q", got)
+	}
+	if got := os.Getenv("DOTENV_TEST_PROCESS"); got != "process" {
+		t.Errorf("DOTENV_TEST_PROCESS = %q, process environment should win", got)
+	}
+	if _, set := os.LookupEnv("DOTENV_TEST_REMOVED"); set {
+		t.Error("variable removed from .env is still set")
+	}
+
+	// a broken .env keeps the current values
+	rewriteConfigFile(t, ".env", "DOTENV_TEST_EDITED='unterminated\n")
+	loadDotEnv()
+	if got := os.Getenv("DOTENV_TEST_EDITED"); got != "2" {
+		t.Errorf("after a broken .env DOTENV_TEST_EDITED = %q", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct{ file, content, want string }{
+		"unknown key":   {"c.yaml", "ldap_urll: ldap://x\n", "ldap_urll"},
+		"bad duration":  {"c.yaml", "token_ttl: 60\n", "line 1"},
+		"invalid toml":  {"c.toml", "service_port = \n", "parse config file"},
+		"unknown type":  {"c.json", "{}", "unsupported format"},
+		"missing file":  {"", "", "read config file"},
+		"unknown toml":  {"c.toml", "nope = 1\n", "nope"},
+		"wrong type":    {"c.yaml", "ldap_pool_max_open: many\n", "line 1"},
+		"bad authz key": {"c.yaml", "authz_rules:\n  crm:\n    allow: [x]\n", "allow"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "missing.yaml")
+			if tc.file != "" {
+				path = writeConfigFile(t, tc.file, tc.content)
+			}
+			_, err := LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want mention of %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigExampleFile(t *testing.T) {
+	if _, err := LoadConfig("config.example.yaml"); err != nil {
+		t.Fatalf("config.example.yaml: %v", err)
+	}
+}
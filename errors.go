@@ -7,18 +7,40 @@ type LDAPErrorCode string
 
 const (
 	// Connection errors
-	ErrConnectionFailed LDAPErrorCode = "connection_failed"
+	ErrConnectionFailed  LDAPErrorCode = "connection_failed"
 	ErrConnectionTimeout LDAPErrorCode = "connection_timeout"
-	ErrTLSFailed LDAPErrorCode = "tls_failed"
+	ErrTLSFailed         LDAPErrorCode = "tls_failed"
 
 	// Authentication errors
-	ErrBindFailed LDAPErrorCode = "bind_failed"
+	ErrBindFailed         LDAPErrorCode = "bind_failed"
 	ErrInvalidCredentials LDAPErrorCode = "invalid_credentials"
 
+	// Account state errors decoded from Active Directory bind sub-codes
+	ErrPasswordExpired       LDAPErrorCode = "password_expired"
+	ErrPasswordMustChange    LDAPErrorCode = "password_must_change"
+	ErrAccountDisabled       LDAPErrorCode = "account_disabled"
+	ErrAccountLocked         LDAPErrorCode = "account_locked"
+	ErrAccountExpired        LDAPErrorCode = "account_expired"
+	ErrInvalidLogonHours     LDAPErrorCode = "invalid_logon_hours"
+	ErrWorkstationRestricted LDAPErrorCode = "workstation_restricted"
+
+	// Password change errors
+	ErrPasswordPolicy       LDAPErrorCode = "password_policy_violation"
+	ErrPasswordInHistory    LDAPErrorCode = "password_in_history"
+	ErrPasswordTooYoung     LDAPErrorCode = "password_too_young"
+	ErrPasswordChangeDenied LDAPErrorCode = "password_change_denied"
+	ErrPasswordChangeFailed LDAPErrorCode = "password_change_failed"
+	ErrUnlockFailed         LDAPErrorCode = "unlock_failed"
+
+	// Authorization errors
+	ErrForbidden   LDAPErrorCode = "forbidden"
+	ErrRateLimited LDAPErrorCode = "rate_limited"
+
 	// Search errors
-	ErrSearchFailed LDAPErrorCode = "search_failed"
-	ErrUserNotFound LDAPErrorCode = "user_not_found"
+	ErrSearchFailed  LDAPErrorCode = "search_failed"
+	ErrUserNotFound  LDAPErrorCode = "user_not_found"
 	ErrSearchTimeout LDAPErrorCode = "search_timeout"
+	ErrGroupNotFound LDAPErrorCode = "group_not_found"
 
 	// Configuration errors
 	ErrInvalidConfig LDAPErrorCode = "invalid_config"
@@ -74,4 +96,3 @@ func GetLDAPErrorCode(err error) LDAPErrorCode {
 	}
 	return ""
 }
-
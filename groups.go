@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Group resolution modes for LDAP_GROUP_MODE
+const (
+	GroupModeMemberOf  = "memberof"  // read memberOf from the user entry
+	GroupModeMember    = "member"    // groupOfNames/groupOfUniqueNames listing the user DN
+	GroupModeMemberUID = "memberuid" // posixGroup listing the username
+	GroupModeADNested  = "ad_nested" // AD transitive membership via LDAP_MATCHING_RULE_IN_CHAIN
+)
+
+// Group name formats for LDAP_GROUP_FORMAT
+const (
+	GroupFormatName = "name" // e.g. "admins"
+	GroupFormatDN   = "dn"   // e.g. "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+// matchingRuleInChain is the AD OID that walks nested group membership.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// groupPageSize keeps group searches below AD's default MaxPageSize.
+const groupPageSize = 500
+
+// Group is a group the user belongs to, with both identifiers so callers
+// can match on either.
+type Group struct {
+	DN   string
+	Name string
+}
+
+// GetUserGroups resolves the groups of a user according to cfg.GroupMode.
+// The connection must be bound with an identity allowed to read groups.
+func (c *LDAPClient) GetUserGroups(ctx context.Context, userDN, username string) ([]Group, error) {
+	switch c.cfg.GroupMode {
+	case "":
+		return nil, nil
+	case GroupModeMemberOf:
+		return c.memberOfGroups(ctx, userDN)
+	case GroupModeMember:
+		dn := ldap.EscapeFilter(userDN)
+		return c.searchGroups(ctx, fmt.Sprintf("(|(&(objectClass=groupOfNames)(member=%s))(&(objectClass=groupOfUniqueNames)(uniqueMember=%s)))", dn, dn))
+	case GroupModeMemberUID:
+		return c.searchGroups(ctx, fmt.Sprintf("(&(objectClass=posixGroup)(memberUid=%s))", ldap.EscapeFilter(username)))
+	case GroupModeADNested:
+		return c.searchGroups(ctx, fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(userDN)))
+	default:
+		return nil, NewLDAPError(ErrInvalidConfig, "unknown group mode "+c.cfg.GroupMode)
+	}
+}
+
+func (c *LDAPClient) memberOfGroups(ctx context.Context, userDN string) ([]Group, error) {
+	req := ldap.NewSearchRequest(
+		userDN,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(c.cfg.RequestTimeout.Seconds()), false,
+		"(objectClass=*)",
+		[]string{"memberOf"},
+		nil,
+	)
+	spanCtx, end := traceLDAP(ctx, opGroupSearch, attribute.String("ldap.group_mode", c.cfg.GroupMode))
+	res, err := c.search(spanCtx, req)
+	end(err)
+	if err != nil {
+		return nil, groupSearchError(ctx, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, NewLDAPError(ErrUserNotFound, "user entry not found")
+	}
+	var groups []Group
+	for _, dn := range res.Entries[0].GetAttributeValues("memberOf") {
+		groups = append(groups, Group{DN: dn, Name: rdnValue(dn)})
+	}
+	return groups, nil
+}
+
+func (c *LDAPClient) searchGroups(ctx context.Context, filter string) ([]Group, error) {
+	req := ldap.NewSearchRequest(
+		c.cfg.GroupBase(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(c.cfg.RequestTimeout.Seconds()), false,
+		filter,
+		[]string{c.cfg.GroupNameAttr},
+		nil,
+	)
+	spanCtx, end := traceLDAP(ctx, opGroupSearch, attribute.String("ldap.group_mode", c.cfg.GroupMode))
+	res, err := c.await(spanCtx, func() (*ldap.SearchResult, error) {
+		return c.conn.SearchWithPaging(req, groupPageSize)
+	})
+	end(err)
+	if err != nil {
+		return nil, groupSearchError(ctx, err)
+	}
+	groups := make([]Group, 0, len(res.Entries))
+	for _, ent := range res.Entries {
+		name := ent.GetAttributeValue(c.cfg.GroupNameAttr)
+		if name == "" {
+			name = rdnValue(ent.DN)
+		}
+		groups = append(groups, Group{DN: ent.DN, Name: name})
+	}
+	return groups, nil
+}
+
+func groupSearchError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return NewLDAPErrorWithCause(ErrSearchTimeout, "group search timeout", err)
+	}
+	log.Error().Err(err).Msg("group search failed")
+	return NewLDAPErrorWithCause(ErrSearchFailed, "group search failed", err)
+}
+
+// rdnValue returns the value of the first RDN of dn, e.g. "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com". Unparseable DNs are returned
+// unchanged.
+func rdnValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// groupNames formats groups for responses and tokens according to
+// cfg.GroupFormat.
+func groupNames(cfg *Config, groups []Group) []string {
+	out := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if strings.EqualFold(cfg.GroupFormat, GroupFormatDN) {
+			out = append(out, g.DN)
+		} else {
+			out = append(out, g.Name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func testGroupClient(mode string, fn func(*ldap.SearchRequest) (*ldap.SearchResult, error)) *LDAPClient {
+	cfg := &Config{
+		UserSearchBase: "dc=example,dc=com",
+		GroupMode:      mode,
+		GroupNameAttr:  "cn",
+		GroupFormat:    GroupFormatName,
+	}
+	return &LDAPClient{cfg: cfg, conn: &fakeConn{searchFn: fn}}
+}
+
+func groupEntries(dns ...string) *ldap.SearchResult {
+	res := &ldap.SearchResult{}
+	for _, dn := range dns {
+		res.Entries = append(res.Entries, ldap.NewEntry(dn, map[string][]string{"cn": {rdnValue(dn)}}))
+	}
+	return res
+}
+
+func TestGetUserGroupsMemberOf(t *testing.T) {
+	userDN := "uid=alice,ou=people,dc=example,dc=com"
+	c := testGroupClient(GroupModeMemberOf, func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		if req.BaseDN != userDN || req.Scope != ldap.ScopeBaseObject {
+			t.Errorf("expected base search on user entry, got %s scope %d", req.BaseDN, req.Scope)
+		}
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(userDN, map[string][]string{
+			"memberOf": {"cn=admins,ou=groups,dc=example,dc=com", "cn=dev,ou=groups,dc=example,dc=com"},
+		})}}, nil
+	})
+
+	groups, err := c.GetUserGroups(context.Background(), userDN, "alice")
+	if err != nil {
+		t.Fatalf("GetUserGroups: %v", err)
+	}
+	if got := groupNames(c.cfg, groups); !reflect.DeepEqual(got, []string{"admins", "dev"}) {
+		t.Errorf("unexpected groups %v", got)
+	}
+	c.cfg.GroupFormat = GroupFormatDN
+	if got := groupNames(c.cfg, groups); got[0] != "cn=admins,ou=groups,dc=example,dc=com" {
+		t.Errorf("expected DN format, got %v", got)
+	}
+}
+
+func TestGetUserGroupsFilters(t *testing.T) {
+	userDN := "uid=a*b,ou=people,dc=example,dc=com"
+	tests := []struct {
+		mode   string
+		filter string
+	}{
+		{GroupModeMember, `(|(&(objectClass=groupOfNames)(member=uid=a\2ab,ou=people,dc=example,dc=com))(&(objectClass=groupOfUniqueNames)(uniqueMember=uid=a\2ab,ou=people,dc=example,dc=com)))`},
+		{GroupModeMemberUID, `(&(objectClass=posixGroup)(memberUid=a\2ab))`},
+		{GroupModeADNested, `(member:1.2.840.113556.1.4.1941:=uid=a\2ab,ou=people,dc=example,dc=com)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			c := testGroupClient(tt.mode, func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+				if req.Filter != tt.filter {
+					t.Errorf("unexpected filter %s", req.Filter)
+				}
+				if req.BaseDN != "dc=example,dc=com" {
+					t.Errorf("expected group search to default to user base, got %s", req.BaseDN)
+				}
+				return groupEntries("cn=staff,ou=groups,dc=example,dc=com"), nil
+			})
+			groups, err := c.GetUserGroups(context.Background(), userDN, "a*b")
+			if err != nil {
+				t.Fatalf("GetUserGroups: %v", err)
+			}
+			if len(groups) != 1 || groups[0].Name != "staff" {
+				t.Errorf("unexpected groups %v", groups)
+			}
+		})
+	}
+}
+
+func TestGetUserGroupsDisabled(t *testing.T) {
+	c := testGroupClient("", nil)
+	groups, err := c.GetUserGroups(context.Background(), "uid=alice,dc=example,dc=com", "alice")
+	if err != nil || groups != nil {
+		t.Errorf("expected no lookup when group mode is empty, got %v, %v", groups, err)
+	}
+}
+
+func TestRdnValue(t *testing.T) {
+	if got := rdnValue("CN=Domain Admins,CN=Users,DC=example,DC=com"); got != "Domain Admins" {
+		t.Errorf("unexpected rdn value %q", got)
+	}
+	if got := rdnValue("not a dn"); got != "not a dn" {
+		t.Errorf("expected unparseable DN to be returned unchanged, got %q", got)
+	}
+}
@@ -3,7 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -15,61 +21,397 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	Ok     bool              `json:"ok"`
-	User   map[string]string `json:"user,omitempty"`
-	Error  string            `json:"error,omitempty"`
-	Detail string            `json:"detail,omitempty"`
+	Ok        bool              `json:"ok"`
+	User      map[string]string `json:"user,omitempty"`
+	Groups    []string          `json:"groups,omitempty"`
+	Token     string            `json:"token,omitempty"`
+	ExpiresAt int64             `json:"expires_at,omitempty"` // token 过期时间 (unix 秒)
+	Error     string            `json:"error,omitempty"`
+	Detail    string            `json:"detail,omitempty"`
+
+	// 目录返回的密码策略状态 (OpenLDAP ppolicy)
+	PasswordPolicy *PasswordPolicy `json:"password_policy,omitempty"`
 }
 
 // POST /v1/auth
-func AuthHandler(cfg *Config) http.HandlerFunc {
+func AuthHandler(app *App) http.HandlerFunc {
+	cfg, pool := app.cfg, app.pool
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req AuthRequest
+		var userDN string
+		ip := clientIP(cfg, r)
+		outcome := outcomeInternalError
+		reserved := false // checkThrottle 已为本次尝试占位
+		defer func() {
+			if reserved {
+				recordThrottle(app, outcome, req.Username, ip)
+			}
+			authAttempts.WithLabelValues(outcome).Inc()
+			ev := auditEventFromRequest(cfg, r, AuditEventAuth)
+			ev.Username, ev.UserDN = req.Username, userDN
+			auditOutcome(&ev, outcome)
+			app.audit.Record(ev)
+		}()
+
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			outcome = outcomeInvalidRequest
 			respondJSON(w, http.StatusBadRequest, AuthResponse{Ok: false, Error: "invalid_json", Detail: err.Error()})
 			return
 		}
 		if req.Username == "" || req.Password == "" {
+			outcome = outcomeInvalidRequest
 			respondJSON(w, http.StatusBadRequest, AuthResponse{Ok: false, Error: "missing_credentials"})
 			return
 		}
+		// DOMAIN\user 以 NTLM 校验密码，在该域的搜索基准下查找；搜索、限流和审计使用不带域的用户名
+		bindName, base := "", cfg.UserSearchBase
+		if domain, user, ok := cfg.ntlmUser(req.Username); ok {
+			bindName, req.Username, base = req.Username, user, cfg.ntlmUserBase(domain)
+		}
+
+		if o, ok := checkThrottle(app, w, r, req.Username, ip); !ok {
+			outcome = o
+			return
+		}
+		reserved = true
 
 		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
 		defer cancel()
 
-		client, err := NewLDAPClient(cfg)
+		client, err := pool.Get(ctx)
 		if err != nil {
-			log.Error().Err(err).Msg("failed to create ldap client")
+			outcome = authOutcome(err, ErrConnectionFailed)
+			log.Error().Err(err).Msg("failed to get ldap connection")
 			respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
 			return
 		}
-		defer client.Close()
+		defer pool.Put(client)
 
 		// 先尝试通过 service bind + search to get user DN (如果配置了)
-		userDN, attrs, err := client.FindUserDN(ctx, req.Username)
+		userDN, attrs, err := client.FindUserDNIn(ctx, base, req.Username)
 		if err != nil {
 			// 不泄露太多细节给外部
+			outcome = authOutcome(err, ErrSearchFailed)
 			log.Debug().Err(err).Str("user", req.Username).Msg("FindUserDN failed")
 			respondJSON(w, http.StatusUnauthorized, AuthResponse{Ok: false, Error: "invalid_credentials"})
 			return
 		}
 
 		// 再用用户 DN bind 校验密码
-		if err := client.AuthenticateWithDN(ctx, userDN, req.Password); err != nil {
-			log.Debug().Err(err).Str("userDN", userDN).Msg("user bind failed")
-			respondJSON(w, http.StatusUnauthorized, AuthResponse{Ok: false, Error: "invalid_credentials"})
+		if bindName == "" {
+			bindName = userDN
+		}
+		policy, err := client.AuthenticateWithPolicy(ctx, bindName, req.Password)
+		if err != nil {
+			if ctx.Err() != nil {
+				outcome = string(ErrConnectionTimeout)
+				log.Debug().Err(err).Str("userDN", userDN).Msg("user bind timed out")
+				respondJSON(w, http.StatusUnauthorized, AuthResponse{Ok: false, Error: "invalid_credentials"})
+				return
+			}
+			// 审计和指标记录真实原因，响应中只暴露配置允许的错误码
+			code := GetLDAPErrorCode(err)
+			outcome = string(code)
+			if code == ErrBindFailed || code == ErrConnectionFailed {
+				// 服务器或连接故障，不是密码错误
+				log.Error().Err(err).Str("userDN", userDN).Msg("user bind failed")
+				respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
+				return
+			}
+			log.Debug().Err(err).Str("userDN", userDN).Str("code", outcome).Msg("user bind failed")
+			resp := AuthResponse{Ok: false, Error: exposedAuthError(cfg, code)}
+			if resp.Error == outcome {
+				resp.PasswordPolicy = policy
+			}
+			respondJSON(w, http.StatusUnauthorized, resp)
+			return
+		}
+
+		// 密码正确后再查询组，组查询需要服务账户身份
+		var userGroups []Group
+		var groups []string
+		if cfg.GroupMode != "" {
+			if err := client.rebind(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to restore service bind for group lookup")
+				respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
+				return
+			}
+			userGroups, err = client.GetUserGroups(ctx, userDN, req.Username)
+			if err != nil {
+				outcome = authOutcome(err, ErrSearchFailed)
+				log.Error().Err(err).Str("userDN", userDN).Msg("group lookup failed")
+				respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "group_lookup_failed"})
+				return
+			}
+			groups = groupNames(cfg, userGroups)
+		}
+
+		// 密码正确但不在允许的组内，与凭据错误区分开
+		clientApp := r.Header.Get(ClientAppHeader)
+		if !cfg.AuthzRuleFor(clientApp).Permits(userGroups) {
+			outcome = string(ErrForbidden)
+			log.Warn().Str("user", req.Username).Str("userDN", userDN).Str("client", clientApp).Msg("user not permitted by group authorization rules")
+			respondJSON(w, http.StatusForbidden, AuthResponse{Ok: false, Error: string(ErrForbidden)})
 			return
 		}
 
 		// 成功 — 返回用户基本信息
 		resp := AuthResponse{
-			Ok:   true,
-			User: attrs,
+			Ok:     true,
+			User:   responseAttributes(cfg, attrs),
+			Groups: groups,
+			// ppolicy 警告，如密码即将过期或剩余宽限登录次数
+			PasswordPolicy: policy,
 		}
+		if app.tokens != nil {
+			token, exp, err := app.tokens.Issue(req.Username, attrs, groups, cfg.AuthzClient(clientApp))
+			if err != nil {
+				log.Error().Err(err).Str("user", req.Username).Msg("failed to sign token")
+				respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "token_error"})
+				return
+			}
+			resp.Token = token
+			resp.ExpiresAt = exp.Unix()
+		}
+		outcome = outcomeSuccess
 		respondJSON(w, http.StatusOK, resp)
 	}
 }
 
+type PasswordChangeRequest struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// POST /v1/password/change
+func PasswordChangeHandler(app *App) http.HandlerFunc {
+	cfg, pool := app.cfg, app.pool
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PasswordChangeRequest
+		var userDN string
+		ip := clientIP(cfg, r)
+		outcome := outcomeInternalError
+		reserved := false // checkThrottle 已为本次尝试占位
+		defer func() {
+			if reserved {
+				recordThrottle(app, outcome, req.Username, ip)
+			}
+			passwordChanges.WithLabelValues(outcome).Inc()
+			ev := auditEventFromRequest(cfg, r, AuditEventPasswordChange)
+			ev.Username, ev.UserDN = req.Username, userDN
+			auditOutcome(&ev, outcome)
+			app.audit.Record(ev)
+		}()
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			outcome = outcomeInvalidRequest
+			respondJSON(w, http.StatusBadRequest, AuthResponse{Ok: false, Error: "invalid_json", Detail: err.Error()})
+			return
+		}
+		if req.Username == "" || req.OldPassword == "" || req.NewPassword == "" {
+			outcome = outcomeInvalidRequest
+			respondJSON(w, http.StatusBadRequest, AuthResponse{Ok: false, Error: "missing_credentials"})
+			return
+		}
+		bindName, base := "", cfg.UserSearchBase
+		if domain, user, ok := cfg.ntlmUser(req.Username); ok {
+			bindName, req.Username, base = req.Username, user, cfg.ntlmUserBase(domain)
+		}
+		if o, ok := checkThrottle(app, w, r, req.Username, ip); !ok {
+			outcome = o
+			return
+		}
+		reserved = true
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		client, err := pool.Get(ctx)
+		if err != nil {
+			outcome = authOutcome(err, ErrConnectionFailed)
+			log.Error().Err(err).Msg("failed to get ldap connection")
+			respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
+			return
+		}
+		defer pool.Put(client)
+
+		userDN, _, err = client.FindUserDNIn(ctx, base, req.Username)
+		if err != nil {
+			outcome = authOutcome(err, ErrSearchFailed)
+			log.Debug().Err(err).Str("user", req.Username).Msg("FindUserDN failed")
+			respondJSON(w, http.StatusUnauthorized, AuthResponse{Ok: false, Error: "invalid_credentials"})
+			return
+		}
+
+		if bindName == "" {
+			bindName = userDN
+		}
+		if err := client.AuthenticateWithDN(ctx, bindName, req.OldPassword); err != nil {
+			code := GetLDAPErrorCode(err)
+			expired := code == ErrPasswordExpired || code == ErrPasswordMustChange
+			switch {
+			case ctx.Err() == nil && cfg.DirectoryType != DirectoryAD && code == ErrPasswordMustChange:
+				// OpenLDAP ppolicy 在管理员重置后 bind 成功但只允许修改密码，继续以用户身份修改
+			case ctx.Err() == nil && cfg.DirectoryType == DirectoryAD && expired:
+				// AD 拒绝过期或必须修改的密码登录；此时改用服务账户连接提交修改，旧密码仍由 AD 校验
+				if err := client.rebind(ctx); err != nil {
+					log.Error().Err(err).Msg("failed to restore service bind for password change")
+					respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
+					return
+				}
+			case code == ErrBindFailed || code == ErrConnectionFailed:
+				outcome = string(code)
+				log.Error().Err(err).Str("userDN", userDN).Msg("user bind failed")
+				respondJSON(w, http.StatusInternalServerError, AuthResponse{Ok: false, Error: "ldap_client_error"})
+				return
+			default:
+				outcome = authOutcome(err, ErrConnectionTimeout)
+				log.Debug().Err(err).Str("userDN", userDN).Msg("user bind failed")
+				respondJSON(w, http.StatusUnauthorized, AuthResponse{Ok: false, Error: exposedAuthError(cfg, code)})
+				return
+			}
+		}
+
+		if err := client.ChangePassword(ctx, userDN, req.OldPassword, req.NewPassword); err != nil {
+			code := GetLDAPErrorCode(err)
+			outcome = string(code)
+			status := http.StatusInternalServerError
+			switch code {
+			case ErrPasswordPolicy, ErrPasswordInHistory, ErrPasswordTooYoung:
+				status = http.StatusBadRequest
+			case ErrInvalidCredentials:
+				status = http.StatusUnauthorized
+			case ErrPasswordChangeDenied:
+				status = http.StatusForbidden
+			}
+			log.Info().Err(err).Str("userDN", userDN).Msg("password change failed")
+			respondJSON(w, status, AuthResponse{Ok: false, Error: string(code)})
+			return
+		}
+
+		log.Info().Str("userDN", userDN).Msg("password changed")
+		outcome = outcomeSuccess
+		respondJSON(w, http.StatusOK, AuthResponse{Ok: true})
+	}
+}
+
+// responseAttributes limits the attributes returned to the caller to
+// ReturnAttributes; attrs may contain extra attributes read for token claims.
+func responseAttributes(cfg *Config, attrs map[string]string) map[string]string {
+	out := make(map[string]string, len(cfg.ReturnAttributes))
+	for _, a := range cfg.ReturnAttributes {
+		if v, ok := attrs[a]; ok {
+			out[a] = v
+		}
+	}
+	return out
+}
+
+// checkThrottle applies the brute-force throttle before credentials are
+// checked against the directory. When the request must not proceed it has
+// already responded and returns the outcome to record. Otherwise the
+// attempt is reserved and must be settled with recordThrottle.
+func checkThrottle(app *App, w http.ResponseWriter, r *http.Request, username, ip string) (string, bool) {
+	if app.throttle == nil {
+		return "", true
+	}
+	retryAfter, delay := app.throttle.Check(username, ip)
+	if retryAfter > 0 {
+		log.Warn().Str("user", username).Str("ip", ip).Dur("retryAfter", retryAfter).Msg("request rejected by local lockout")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondJSON(w, http.StatusTooManyRequests, AuthResponse{Ok: false, Error: string(ErrRateLimited)})
+		return string(ErrRateLimited), false
+	}
+	// 渐进延迟在获取 LDAP 连接之前进行，不占用连接池
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			app.throttle.Release(username, ip)
+			return outcomeInvalidRequest, false
+		}
+	}
+	return "", true
+}
+
+// recordThrottle feeds the outcome of a credential check back into the
+// brute-force throttle, settling the attempt reserved by checkThrottle.
+func recordThrottle(app *App, outcome, username, ip string) {
+	if app.throttle == nil {
+		return
+	}
+	switch LDAPErrorCode(outcome) {
+	case ErrInvalidCredentials, ErrUserNotFound:
+		app.throttle.Failure(username, ip)
+	case outcomeSuccess, ErrForbidden:
+		// 密码正确（即使无权访问）时清除该用户的失败记录
+		app.throttle.Success(username, ip)
+	default:
+		app.throttle.Release(username, ip)
+	}
+}
+
+// clientIP returns the caller's IP address. Forwarding headers are only
+// honoured when TrustProxyHeaders is set, since clients can forge them.
+// X-Forwarded-For is read from the right: each proxy appends the address it
+// received the request from, so the first hop that is not one of
+// TrustedProxies is the client. Without TrustedProxies only the immediate
+// peer is trusted. Entries left of that hop are chosen by the client.
+func clientIP(cfg *Config, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !cfg.TrustProxyHeaders || (len(cfg.TrustedProxies) > 0 && !cfg.isTrustedProxy(host)) {
+		return host
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if i == 0 || !cfg.isTrustedProxy(hops[i]) {
+			return hops[i]
+		}
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return strings.TrimSpace(ip)
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip is covered by TrustedProxies.
+func (c *Config) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, p := range c.TrustedProxies {
+		if prefix, err := parseProxyPrefix(p); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxyPrefix parses a TRUSTED_PROXIES entry, a CIDR or a single
+// address.
+func parseProxyPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
 func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -4,20 +4,51 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"strings"
+	"time"
 
 	ldap "github.com/go-ldap/ldap/v3"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type LDAPClient struct {
 	cfg  *Config
-	conn *ldap.Conn
+	conn ldap.Client
+	// address is the URL of the server this connection was dialed to
+	address string
+
+	createdAt time.Time
+	// userBound is set once the connection has been bound as an end user,
+	// so the pool knows to re-bind it as the service account before reuse.
+	userBound bool
+	// broken marks a connection whose state is unknown (e.g. an operation
+	// timed out while still in flight); the pool discards it on release.
+	broken bool
 }
 
+// NewLDAPClient dials a single, unpooled connection to the first reachable
+// configured server. Request handlers should borrow connections from an
+// LDAPPool instead.
 func NewLDAPClient(cfg *Config) (*LDAPClient, error) {
-	// Dial each request to keep isolation and avoid pool complexity;
-	// 如果需要高性能可实现连接池复用。
-	address := cfg.LDAPURL
+	return NewServerSet(cfg).Dial(context.Background())
+}
+
+func newLDAPClient(parent context.Context, cfg *Config, address string) (*LDAPClient, error) {
+	ctx, cancel := context.WithTimeout(parent, cfg.ConnTimeout)
+	defer cancel()
+	dialCtx, endDial := traceLDAP(ctx, opDial, semconv.ServerAddress(address))
+
+	var tlsCfg *tls.Config
+	if cfg.UseStartTLS || cfg.UseLDAPS || strings.HasPrefix(address, "ldaps://") {
+		var err error
+		if tlsCfg, err = ldapTLSConfig(cfg, address); err != nil {
+			endDial(err)
+			log.Error().Err(err).Str("address", address).Msg("invalid LDAP TLS configuration")
+			return nil, err
+		}
+	}
 
 	// Use goroutine + channel to implement connection timeout
 	type dialResult struct {
@@ -36,13 +67,15 @@ func NewLDAPClient(cfg *Config) (*LDAPClient, error) {
 				dialCh <- dialResult{nil, err}
 				return
 			}
-			if err = l.StartTLS(&tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}); err != nil {
+			_, endTLS := traceLDAP(dialCtx, opStartTLS)
+			err = l.StartTLS(tlsCfg)
+			endTLS(err)
+			if err != nil {
 				l.Close()
 				dialCh <- dialResult{nil, err}
 				return
 			}
-		} else if cfg.UseLDAPS {
-			tlsCfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+		} else if tlsCfg != nil {
 			l, err = ldap.DialURL(address, ldap.DialWithTLSConfig(tlsCfg))
 			if err != nil {
 				dialCh <- dialResult{nil, err}
@@ -59,15 +92,14 @@ func NewLDAPClient(cfg *Config) (*LDAPClient, error) {
 	}()
 
 	// Wait for connection with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnTimeout)
-	defer cancel()
-
 	var l *ldap.Conn
 	select {
 	case <-ctx.Done():
+		endDial(ctx.Err())
 		log.Error().Str("address", address).Dur("timeout", cfg.ConnTimeout).Msg("LDAP connection timeout")
 		return nil, NewLDAPErrorWithCause(ErrConnectionTimeout, "connection timeout", ctx.Err())
 	case result := <-dialCh:
+		endDial(result.err)
 		if result.err != nil {
 			if cfg.UseStartTLS {
 				log.Error().Err(result.err).Str("address", address).Msg("failed to dial LDAP server for StartTLS")
@@ -83,14 +115,12 @@ func NewLDAPClient(cfg *Config) (*LDAPClient, error) {
 		l = result.conn
 	}
 
+	client := &LDAPClient{cfg: cfg, conn: l, address: address, createdAt: time.Now()}
+
 	// Optional: service bind for search operations
-	if cfg.BindDN != "" && cfg.BindPassword != "" {
-		if err := l.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
-			l.Close()
-			log.Error().Err(err).Str("bindDN", cfg.BindDN).Msg("failed to bind with service account")
-			return nil, NewLDAPErrorWithCause(ErrBindFailed, "failed to bind with service account", err)
-		}
-		log.Debug().Str("bindDN", cfg.BindDN).Msg("service account bind successful")
+	if err := client.bindService(parent); err != nil {
+		l.Close()
+		return nil, err
 	}
 
 	if cfg.UseStartTLS {
@@ -101,7 +131,103 @@ func NewLDAPClient(cfg *Config) (*LDAPClient, error) {
 		log.Debug().Str("address", address).Msg("LDAP connection established")
 	}
 
-	return &LDAPClient{cfg: cfg, conn: l}, nil
+	return client, nil
+}
+
+// Service account bind methods for LDAP_BIND_METHOD
+const (
+	BindMethodSimple   = "simple"   // LDAP_BIND_DN / LDAP_BIND_PASSWORD, or anonymous when unset
+	BindMethodExternal = "external" // SASL EXTERNAL: identity from the TLS client certificate or ldapi:// peer
+	BindMethodNTLM     = "ntlm"     // NTLM with LDAP_BIND_DN as DOMAIN\user and a password or NT hash (AD)
+)
+
+// bindService binds the connection as the configured service account, or
+// anonymously when none is configured. A bind still in flight when ctx ends
+// marks the connection broken.
+func (c *LDAPClient) bindService(ctx context.Context) error {
+	ch := make(chan error, 1)
+	go func() { ch <- c.serviceBind(ctx) }()
+	select {
+	case <-ctx.Done():
+		c.broken = true
+		log.Error().Str("address", c.address).Msg("service bind timeout")
+		return NewLDAPErrorWithCause(ErrConnectionTimeout, "service bind timeout", ctx.Err())
+	case err := <-ch:
+		return err
+	}
+}
+
+func (c *LDAPClient) serviceBind(ctx context.Context) error {
+	if c.cfg.BindMethod == BindMethodExternal {
+		_, end := traceLDAP(ctx, opServiceBind, attribute.String("ldap.bind.mechanism", "EXTERNAL"))
+		err := c.conn.ExternalBind()
+		end(err)
+		if err != nil {
+			log.Error().Err(err).Str("address", c.address).Msg("failed to bind with SASL EXTERNAL")
+			return NewLDAPErrorWithCause(ErrBindFailed, "failed to bind with SASL EXTERNAL", err)
+		}
+		log.Debug().Str("address", c.address).Msg("service bind with SASL EXTERNAL successful")
+		return nil
+	}
+	if c.cfg.BindMethod == BindMethodNTLM {
+		domain, user := splitDomainUser(c.cfg.BindDN)
+		_, end := traceLDAP(ctx, opServiceBind, attribute.String("ldap.bind.mechanism", "NTLM"))
+		err := c.ntlmBind(domain, user, c.cfg.BindPassword, c.cfg.BindNTHash)
+		end(err)
+		if err != nil {
+			log.Error().Err(err).Str("bindDN", c.cfg.BindDN).Msg("failed to bind with service account using NTLM")
+			return NewLDAPErrorWithCause(ErrBindFailed, "failed to bind with service account", err)
+		}
+		log.Debug().Str("bindDN", c.cfg.BindDN).Msg("service account NTLM bind successful")
+		return nil
+	}
+	if c.cfg.BindDN != "" && c.cfg.BindPassword != "" {
+		_, end := traceLDAP(ctx, opServiceBind)
+		err := c.conn.Bind(c.cfg.BindDN, c.cfg.BindPassword)
+		end(err)
+		if err != nil {
+			log.Error().Err(err).Str("bindDN", c.cfg.BindDN).Msg("failed to bind with service account")
+			return NewLDAPErrorWithCause(ErrBindFailed, "failed to bind with service account", err)
+		}
+		log.Debug().Str("bindDN", c.cfg.BindDN).Msg("service account bind successful")
+		return nil
+	}
+	if c.userBound {
+		// 没有服务账户时，退回匿名身份，避免后续请求沿用上一个用户的身份
+		if err := c.conn.UnauthenticatedBind(""); err != nil {
+			return NewLDAPErrorWithCause(ErrBindFailed, "failed to reset to anonymous bind", err)
+		}
+	}
+	return nil
+}
+
+// rebind restores the service identity after a user bind so the connection
+// can be handed to the next request.
+func (c *LDAPClient) rebind(ctx context.Context) error {
+	if !c.userBound {
+		return nil
+	}
+	if err := c.bindService(ctx); err != nil {
+		return err
+	}
+	c.userBound = false
+	return nil
+}
+
+// ping performs a cheap root DSE read to verify the connection is usable.
+func (c *LDAPClient) ping(ctx context.Context) error {
+	if c.conn == nil || c.conn.IsClosing() {
+		return NewLDAPError(ErrConnectionFailed, "connection closed")
+	}
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{"1.1"}, nil)
+	if _, err := c.search(ctx, req); err != nil {
+		if ctx.Err() != nil {
+			return NewLDAPErrorWithCause(ErrConnectionTimeout, "health check timeout", err)
+		}
+		return NewLDAPErrorWithCause(ErrConnectionFailed, "health check failed", err)
+	}
+	return nil
 }
 
 func (c *LDAPClient) Close() {
@@ -112,71 +238,149 @@ func (c *LDAPClient) Close() {
 
 // FindUserDN uses configured searchBase & filter to find the user's DN and attributes
 func (c *LDAPClient) FindUserDN(ctx context.Context, username string) (string, map[string]string, error) {
+	return c.FindUserDNIn(ctx, c.cfg.UserSearchBase, username)
+}
+
+// FindUserDNIn is FindUserDN searching below base instead of LDAP_USER_BASE.
+func (c *LDAPClient) FindUserDNIn(ctx context.Context, base, username string) (string, map[string]string, error) {
+	return c.findUserIn(ctx, base, username, c.cfg.UserAttributes())
+}
+
+// findUser is FindUserDN reading the given attributes.
+func (c *LDAPClient) findUser(ctx context.Context, username string, attributes []string) (string, map[string]string, error) {
+	return c.findUserIn(ctx, c.cfg.UserSearchBase, username, attributes)
+}
+
+func (c *LDAPClient) findUserIn(ctx context.Context, base, username string, attributes []string) (string, map[string]string, error) {
 	// prepare filter
 	filter := fmt.Sprintf(c.cfg.UserSearchFilter, ldap.EscapeFilter(username))
 	searchReq := ldap.NewSearchRequest(
-		c.cfg.UserSearchBase,
+		base,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(c.cfg.RequestTimeout.Seconds()), false,
 		filter,
-		c.cfg.ReturnAttributes,
+		attributes,
 		nil,
 	)
-	// Use Context deadline via goroutine and channel because go-ldap doesn't accept context directly
+	spanCtx, end := traceLDAP(ctx, opSearch, attribute.String("ldap.base_dn", base))
+	res, err := c.search(spanCtx, searchReq)
+	end(err)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Warn().Str("username", username).Msg("user search timeout")
+			return "", nil, NewLDAPErrorWithCause(ErrSearchTimeout, "user search timeout", err)
+		}
+		log.Error().Err(err).Str("username", username).Msg("user search failed")
+		return "", nil, NewLDAPErrorWithCause(ErrSearchFailed, "user search failed", err)
+	}
+	if len(res.Entries) == 0 {
+		log.Debug().Str("username", username).Msg("user not found in LDAP")
+		return "", nil, NewLDAPError(ErrUserNotFound, "user not found")
+	}
+	ent := res.Entries[0]
+	attrs := map[string]string{}
+	for _, a := range attributes {
+		if len(ent.GetAttributeValues(a)) > 0 {
+			attrs[a] = ent.GetAttributeValue(a)
+		}
+	}
+	// If cfg.UserDNAttr is set, prefer it; otherwise use entry.DN
+	dn := ent.DN
+	if c.cfg.UserDNAttr != "" {
+		if v := ent.GetAttributeValue(c.cfg.UserDNAttr); v != "" {
+			dn = v
+		}
+	}
+	log.Debug().Str("dn", dn).Msg("user found")
+	return dn, attrs, nil
+}
+
+// search runs a search bounded by ctx.
+func (c *LDAPClient) search(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	return c.await(ctx, func() (*ldap.SearchResult, error) { return c.conn.Search(req) })
+}
+
+// await runs op in a goroutine and waits for it or ctx, whichever comes
+// first, because go-ldap doesn't accept context directly. A connection left
+// with an operation in flight is marked broken so the pool drops it.
+func (c *LDAPClient) await(ctx context.Context, op func() (*ldap.SearchResult, error)) (*ldap.SearchResult, error) {
 	type result struct {
 		res *ldap.SearchResult
 		err error
 	}
 	ch := make(chan result, 1)
 	go func() {
-		res, err := c.conn.Search(searchReq)
+		res, err := op()
 		ch <- result{res: res, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		log.Warn().Str("username", username).Msg("user search timeout")
-		return "", nil, NewLDAPErrorWithCause(ErrSearchTimeout, "user search timeout", ctx.Err())
+		c.broken = true
+		return nil, ctx.Err()
 	case r := <-ch:
-		if r.err != nil {
-			log.Error().Err(r.err).Str("username", username).Msg("user search failed")
-			return "", nil, NewLDAPErrorWithCause(ErrSearchFailed, "user search failed", r.err)
-		}
-		if len(r.res.Entries) == 0 {
-			log.Debug().Str("username", username).Msg("user not found in LDAP")
-			return "", nil, NewLDAPError(ErrUserNotFound, "user not found")
-		}
-		ent := r.res.Entries[0]
-		attrs := map[string]string{}
-		for _, a := range c.cfg.ReturnAttributes {
-			if len(ent.GetAttributeValues(a)) > 0 {
-				attrs[a] = ent.GetAttributeValue(a)
-			}
-		}
-		// If cfg.UserDNAttr is set, prefer it; otherwise use entry.DN
-		dn := ent.DN
-		if c.cfg.UserDNAttr != "" {
-			if v := ent.GetAttributeValue(c.cfg.UserDNAttr); v != "" {
-				dn = v
-			}
-		}
-		log.Debug().Str("dn", dn).Msg("user found")
-		return dn, attrs, nil
+		return r.res, r.err
 	}
 }
 
-// AuthenticateWithDN attempts bind using user DN + password
+// AuthenticateWithDN attempts bind using user DN + password. A
+// "DOMAIN\user" name for one of LDAP_USER_NTLM_DOMAINS is bound with NTLM
+// instead.
 func (c *LDAPClient) AuthenticateWithDN(ctx context.Context, userDN, password string) error {
-	type res struct{ err error }
+	_, err := c.AuthenticateWithPolicy(ctx, userDN, password)
+	return err
+}
+
+// AuthenticateWithPolicy binds as the user like AuthenticateWithDN and also
+// returns the password policy state reported by the directory, if any. A
+// bind that succeeds but requires a password change after an admin reset
+// is reported as ErrPasswordMustChange.
+func (c *LDAPClient) AuthenticateWithPolicy(ctx context.Context, userDN, password string) (*PasswordPolicy, error) {
+	type res struct {
+		policy *PasswordPolicy
+		err    error
+	}
 	ch := make(chan res, 1)
+	c.userBound = true
+	_, end := traceLDAP(ctx, opUserBind)
 	go func() {
-		err := c.conn.Bind(userDN, password)
-		ch <- res{err: err}
+		if domain, user, ok := c.cfg.ntlmUser(userDN); ok {
+			ch <- res{err: c.ntlmBind(domain, user, password, "")}
+			return
+		}
+		if !c.usePasswordPolicy() {
+			ch <- res{err: c.conn.Bind(userDN, password)}
+			return
+		}
+		req := ldap.NewSimpleBindRequest(userDN, password, []ldap.Control{ldap.NewControlBeheraPasswordPolicy()})
+		result, err := c.conn.SimpleBind(req)
+		var policy *PasswordPolicy
+		if result != nil {
+			policy = parsePasswordPolicy(result.Controls)
+		}
+		ch <- res{policy: policy, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		c.broken = true
+		end(ctx.Err())
+		return nil, ctx.Err()
 	case r := <-ch:
-		return r.err
+		end(r.err)
+		if r.err != nil {
+			err := bindError(r.err)
+			if GetLDAPErrorCode(err) == ErrConnectionFailed {
+				c.broken = true
+			}
+			// ppolicy 控制给出的原因比普通的 invalidCredentials 更具体
+			if r.policy != nil && r.policy.Error != "" {
+				err = NewLDAPErrorWithCause(LDAPErrorCode(r.policy.Error), "user bind rejected by password policy", r.err)
+			}
+			return r.policy, err
+		}
+		if r.policy != nil && r.policy.Error == string(ErrPasswordMustChange) {
+			return r.policy, NewLDAPError(ErrPasswordMustChange, "password must be changed after reset")
+		}
+		return r.policy, nil
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -45,7 +46,7 @@ func TestNewLDAPClientConnectionTimeout(t *testing.T) {
 	// Use an unreachable address to trigger timeout
 	cfg := &Config{
 		LDAPURL:     "ldap://192.0.2.1:389", // TEST-NET-1 (unreachable)
-		ConnTimeout: 100 * time.Millisecond,  // Very short timeout
+		ConnTimeout: 100 * time.Millisecond, // Very short timeout
 	}
 
 	client, err := NewLDAPClient(cfg)
@@ -110,3 +111,18 @@ func TestLDAPErrorUnwrap(t *testing.T) {
 	}
 }
 
+func TestBindServiceExternal(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{BindMethod: BindMethodExternal, BindDN: "cn=ignored"}, conn: fc, userBound: true}
+	if err := c.rebind(t.Context()); err != nil {
+		t.Fatalf("rebind: %v", err)
+	}
+	if len(fc.binds) != 1 || fc.binds[0] != "EXTERNAL" || c.userBound {
+		t.Errorf("binds = %v, userBound = %v", fc.binds, c.userBound)
+	}
+
+	fc.bindErr = errors.New("inappropriate authentication")
+	if err := c.bindService(t.Context()); GetLDAPErrorCode(err) != ErrBindFailed {
+		t.Errorf("err = %v, want bind_failed", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ldapTLSVersions maps LDAP_TLS_MIN_VERSION values to TLS versions.
+var ldapTLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ldapTLSConfig builds the TLS configuration for an LDAPS or StartTLS
+// connection to address. Certificate files are read on every call, so new
+// connections pick up rotated certificates.
+func ldapTLSConfig(cfg *Config, address string) (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ServerName:         cfg.LDAPTLSServerName,
+	}
+	if tc.ServerName == "" {
+		// StartTLS 不会像 tls.Dial 那样从地址推断主机名
+		if u, err := url.Parse(address); err == nil {
+			tc.ServerName = u.Hostname()
+		}
+	}
+
+	var err error
+	if tc.MinVersion, err = parseTLSVersion(cfg.LDAPTLSMinVersion); err != nil {
+		return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "invalid LDAP_TLS_MIN_VERSION", err)
+	}
+	if tc.CipherSuites, err = parseCipherSuites(cfg.LDAPTLSCipherSuites); err != nil {
+		return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "invalid LDAP_TLS_CIPHER_SUITES", err)
+	}
+
+	if cfg.LDAPTLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.LDAPTLSCAFile)
+		if err != nil {
+			return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "failed to read LDAP CA bundle", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, NewLDAPError(ErrInvalidConfig, fmt.Sprintf("no certificates found in %s", cfg.LDAPTLSCAFile))
+		}
+		tc.RootCAs = pool
+	}
+	if cfg.LDAPTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.LDAPTLSCert, cfg.LDAPTLSKey)
+		if err != nil {
+			return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "failed to load LDAP client certificate", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.LDAPTLSPins) > 0 {
+		pins, err := parseSPKIPins(cfg.LDAPTLSPins)
+		if err != nil {
+			return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "invalid LDAP_TLS_PINS", err)
+		}
+		// 在常规校验之后执行，LDAP_INSECURE_SKIP_VERIFY=1 时仅依赖证书固定。
+		// 服务器可以在任意叶子证书后附带被固定的证书，所以只匹配已验证的链，
+		// 跳过校验时只匹配叶子证书
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			var certs []*x509.Certificate
+			if tc.InsecureSkipVerify {
+				certs = cs.PeerCertificates[:min(1, len(cs.PeerCertificates))]
+			} else {
+				for _, chain := range cs.VerifiedChains {
+					certs = append(certs, chain...)
+				}
+			}
+			for _, cert := range certs {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no certificate presented by %s matches LDAP_TLS_PINS", cs.ServerName)
+		}
+	}
+	return tc, nil
+}
+
+// parseTLSVersion parses "1.0" to "1.3"; empty means TLS 1.2.
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := ldapTLSVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, use 1.0, 1.1, 1.2 or 1.3", v)
+	}
+	return version, nil
+}
+
+// parseCipherSuites maps Go cipher suite names (e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to IDs. Suites Go considers
+// insecure are rejected. nil means Go's defaults.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	insecure := map[string]bool{}
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(name)]
+		switch {
+		case insecure[strings.ToUpper(name)]:
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseSPKIPins decodes pins given as base64 SHA-256 hashes of a
+// certificate's SubjectPublicKeyInfo, optionally prefixed with "sha256/" as
+// in curl's --pinnedpubkey.
+func parseSPKIPins(pins []string) ([][]byte, error) {
+	out := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a base64 SHA-256 hash", pin)
+		}
+		out = append(out, sum)
+	}
+	return out, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTLSServer accepts connections and completes the handshake with tc,
+// returning its address.
+func startTLSServer(t *testing.T, tc *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestLDAPTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeTestCert(t, dir, "ldap.example.com")
+	otherCA, _, _ := writeTestCert(t, t.TempDir(), "ldap.example.com")
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	addr := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+	wrongPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for name, tc := range map[string]struct {
+		cfg  Config
+		want string // 为空表示握手成功
+	}{
+		"ca and client cert": {cfg: Config{LDAPTLSCAFile: certFile, LDAPTLSCert: certFile, LDAPTLSKey: keyFile, LDAPTLSServerName: "ldap.example.com"}},
+		"pinned":             {cfg: Config{LDAPTLSCAFile: certFile, LDAPTLSCert: certFile, LDAPTLSKey: keyFile, LDAPTLSServerName: "ldap.example.com", LDAPTLSPins: []string{wrongPin, pin}}},
+		"pin only":           {cfg: Config{InsecureSkipVerify: true, LDAPTLSCert: certFile, LDAPTLSKey: keyFile, LDAPTLSPins: []string{pin}}},
+		"pin mismatch":       {cfg: Config{InsecureSkipVerify: true, LDAPTLSCert: certFile, LDAPTLSKey: keyFile, LDAPTLSPins: []string{wrongPin}}, want: "matches LDAP_TLS_PINS"},
+		"untrusted ca":       {cfg: Config{LDAPTLSCAFile: otherCA, LDAPTLSCert: certFile, LDAPTLSKey: keyFile, LDAPTLSServerName: "ldap.example.com"}, want: "unknown authority"},
+		"host from url":      {cfg: Config{LDAPTLSCAFile: certFile, LDAPTLSCert: certFile, LDAPTLSKey: keyFile}, want: "127.0.0.1"},
+		"no client cert":     {cfg: Config{LDAPTLSCAFile: certFile, LDAPTLSServerName: "ldap.example.com"}, want: "certificate required"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tlsCfg, err := ldapTLSConfig(&tc.cfg, "ldaps://"+addr)
+			if err != nil {
+				t.Fatalf("ldapTLSConfig: %v", err)
+			}
+			conn, err := tls.Dial("tcp", addr, tlsCfg)
+			if err == nil {
+				// TLS 1.3 的客户端证书错误在握手后的第一次读取时才返回
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err = conn.Read(make([]byte, 1)); errors.Is(err, io.EOF) {
+					err = nil
+				}
+				conn.Close()
+			}
+			switch {
+			case tc.want == "" && err != nil:
+				t.Errorf("handshake failed: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Errorf("err = %v, want mention of %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLDAPTLSPinsIgnoreExtraCertificates(t *testing.T) {
+	leafFile, leafKey, _ := writeTestCert(t, t.TempDir(), "ldap.example.com")
+	_, _, pinned := writeTestCert(t, t.TempDir(), "ldap.example.com")
+	serverCert, err := tls.LoadX509KeyPair(leafFile, leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务器用无关的叶子证书握手，同时附带被固定的证书
+	serverCert.Certificate = append(serverCert.Certificate, pinned.Raw)
+	addr := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	sum := sha256.Sum256(pinned.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	for name, cfg := range map[string]Config{
+		"verified chain": {LDAPTLSCAFile: leafFile, LDAPTLSServerName: "ldap.example.com", LDAPTLSPins: []string{pin}},
+		"skip verify":    {InsecureSkipVerify: true, LDAPTLSPins: []string{pin}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tlsCfg, err := ldapTLSConfig(&cfg, "ldaps://"+addr)
+			if err != nil {
+				t.Fatalf("ldapTLSConfig: %v", err)
+			}
+			conn, err := tls.Dial("tcp", addr, tlsCfg)
+			if err == nil {
+				conn.Close()
+				t.Fatal("handshake accepted a pinned certificate outside the verified chain")
+			}
+			if !strings.Contains(err.Error(), "matches LDAP_TLS_PINS") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestLDAPTLSConfigSettings(t *testing.T) {
+	tc, err := ldapTLSConfig(&Config{LDAPTLSMinVersion: "1.3", LDAPTLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, "ldap://dc1.example.com:389")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.MinVersion != tls.VersionTLS13 || len(tc.CipherSuites) != 1 || tc.ServerName != "dc1.example.com" || tc.RootCAs != nil {
+		t.Errorf("unexpected TLS config %+v", tc)
+	}
+
+	for _, cfg := range []Config{
+		{LDAPTLSMinVersion: "1.4"},
+		{LDAPTLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{LDAPTLSPins: []string{"c2hvcnQ="}},
+		{LDAPTLSCAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := ldapTLSConfig(&cfg, "ldaps://dc1.example.com"); GetLDAPErrorCode(err) != ErrInvalidConfig {
+			t.Errorf("%+v: err = %v", cfg, err)
+		}
+	}
+}
+
+func TestValidateLDAPTLS(t *testing.T) {
+	c := defaultConfig()
+	c.InsecureSkipVerify = true
+	c.LDAPTLSCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	c.LDAPTLSCert = "client.pem"
+	c.LDAPTLSMinVersion = "1.5"
+	c.LDAPTLSCipherSuites = []string{"TLS_FAKE"}
+	c.LDAPTLSPins = []string{"nope"}
+
+	err := c.Validate()
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	for _, want := range []string{
+		"LDAP_INSECURE_SKIP_VERIFY: skips verification",
+		"LDAP_TLS_CA_FILE: cannot read",
+		"LDAP_TLS_CA_FILE: has no effect",
+		"LDAP_TLS_CERT: LDAP_TLS_CERT and LDAP_TLS_KEY must be set together",
+		"LDAP_TLS_CIPHER_SUITES: unknown cipher suite",
+		"LDAP_TLS_MIN_VERSION: unknown TLS version",
+		"LDAP_TLS_PINS: pin \"nope\"",
+	} {
+		found := false
+		for _, p := range problems {
+			found = found || strings.HasPrefix(p, want)
+		}
+		if !found {
+			t.Errorf("missing problem %q in:\n%s", want, strings.Join(problems, "\n"))
+		}
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -16,7 +18,27 @@ import (
 )
 
 func main() {
-	cfg := LoadConfigFromEnv()
+	configPath := flag.String("config", "", "YAML or TOML config file (default $"+ConfigFileEnv+")")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [check-config]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+	case "check-config":
+		os.Exit(checkConfig(*configPath, os.Stdout, os.Stderr))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadValidConfig(*configPath)
+	if err != nil {
+		writeConfigError(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	// Configure logger based on config
 	logLevel := parseLogLevel(cfg.LogLevel)
@@ -41,43 +63,110 @@ func main() {
 
 	log.Info().Interface("config", cfg.ToMap()).Msg("starting ldap microservice")
 
-	router := mux.NewRouter()
-	// routes
-	// 使用配置的 BasePath 前缀注册路由
-	basePath := cfg.BasePath
-	router.HandleFunc(basePath+"/v1/auth", AuthHandler(cfg)).Methods("POST")
-	router.HandleFunc(basePath+"/v1/healthz", HealthHandler).Methods("GET")
-	router.HandleFunc(basePath+"/v1/readyz", ReadyHandler).Methods("GET")
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialise tracing")
+	}
 
+	// 配置热加载：SIGHUP 或配置文件变化时替换配置、连接池和路由
+	configFile := configFilePath(*configPath)
+	reloader, err := NewReloader(configFile, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialise service")
+	}
+	reloader.Watch(cfg.ConfigWatchInterval)
+	reloader.RefreshSecrets(cfg.SecretRefreshInterval)
+	defer reloader.Close()
+
+	registerPoolMetrics(reloader.PoolStats)
+
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to configure service TLS")
+	}
 	srv := &http.Server{
 		Addr:         ":" + cfg.ServicePort,
-		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Handler:      reloader,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
 	}
 
 	// graceful shutdown
 	go func() {
-		log.Info().Msgf("listening on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info().Bool("tls", tlsConfig != nil).Msgf("listening on %s", srv.Addr)
+		serve := srv.ListenAndServe
+		if tlsConfig != nil {
+			// 证书已在 TLSConfig 中加载
+			serve = func() error { return srv.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("server failed")
 		}
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
-	<-quit
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case <-hup:
+			_ = reloader.Reload("SIGHUP")
+		case <-quit:
+			break wait
+		}
+	}
 	log.Info().Msg("shutdown signal received")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), reloader.Config().ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("server shutdown error")
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush traces")
+	}
 	log.Info().Msg("server exited")
 }
 
+// newRouter registers the API routes for one configuration generation.
+func newRouter(app *App) http.Handler {
+	cfg := app.cfg
+	router := mux.NewRouter()
+	router.Use(inFlightMiddleware, tracingMiddleware)
+	// routes
+	// 使用配置的 BasePath 前缀注册路由
+	basePath := cfg.BasePath
+	router.HandleFunc(basePath+"/v1/auth", AuthHandler(app)).Methods("POST")
+	router.HandleFunc(basePath+"/v1/password/change", PasswordChangeHandler(app)).Methods("POST")
+	router.HandleFunc(basePath+"/v1/healthz", HealthHandler).Methods("GET")
+	router.HandleFunc(basePath+"/v1/readyz", ReadyHandler).Methods("GET")
+	if len(cfg.AdminAPIKeys) > 0 {
+		router.HandleFunc(basePath+"/v1/admin/users/{username}/password", requireAdmin(cfg, PasswordResetHandler(app))).Methods("POST")
+		router.HandleFunc(basePath+"/v1/admin/users/{username}/unlock", requireAdmin(cfg, UnlockHandler(app))).Methods("POST")
+		if app.throttle != nil {
+			router.HandleFunc(basePath+"/v1/admin/lockouts", requireAdmin(cfg, LockoutsHandler(app))).Methods("GET")
+			router.HandleFunc(basePath+"/v1/admin/lockouts/{type}/{key}", requireAdmin(cfg, ClearLockoutHandler(app))).Methods("DELETE")
+		}
+	}
+	if app.cursors != nil {
+		router.HandleFunc(basePath+"/v1/users", requireLookupCaller(cfg, UserSearchHandler(app))).Methods("GET")
+		router.HandleFunc(basePath+"/v1/groups", requireLookupCaller(cfg, GroupSearchHandler(app))).Methods("GET")
+		router.HandleFunc(basePath+"/v1/users/{username}", requireLookupCaller(cfg, UserLookupHandler(app))).Methods("GET")
+		router.HandleFunc(basePath+"/v1/groups/{group}/members", requireLookupCaller(cfg, GroupMembersHandler(app))).Methods("GET")
+	}
+	if cfg.MetricsEnabled {
+		router.Handle(basePath+"/metrics", MetricsHandler()).Methods("GET")
+	}
+	if app.tokens != nil {
+		router.HandleFunc(basePath+"/.well-known/jwks.json", JWKSHandler(app.tokens)).Methods("GET")
+	}
+	return router
+}
+
 func parseLogLevel(level string) zerolog.Level {
 	switch strings.ToLower(level) {
 	case "debug":
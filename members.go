@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Member attributes: groupOfNames/AD, groupOfUniqueNames, posixGroup
+const (
+	attrMember       = "member"
+	attrUniqueMember = "uniqueMember"
+	attrMemberUID    = "memberUid"
+)
+
+// memberBatchSize bounds the member entries read with one OR filter.
+const memberBatchSize = 100
+
+// groupObjectClasses identify member entries that are themselves groups.
+var groupObjectClasses = []string{"group", "groupOfNames", "groupOfUniqueNames", "posixGroup"}
+
+// rangePattern matches AD ranged attribute names, e.g. "member;range=0-1499"
+// or "member;range=1500-*" for the last chunk.
+var rangePattern = regexp.MustCompile(`(?i)^member;range=(\d+)-(\d+|\*)$`)
+
+// usernamePattern extracts the username attribute from LDAP_USER_FILTER,
+// e.g. "uid" from "(uid=%s)".
+var usernamePattern = regexp.MustCompile(`\(([A-Za-z][A-Za-z0-9-]*)=%s\)`)
+
+// GroupMember is a user in a group membership listing.
+type GroupMember struct {
+	DN         string            `json:"dn"`
+	Username   string            `json:"username,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// GroupMembers is the membership of a group.
+type GroupMembers struct {
+	Group   GroupSummary  `json:"group"`
+	Members []GroupMember `json:"members"`
+	// Groups lists member groups that were not expanded
+	Groups []GroupSummary `json:"groups,omitempty"`
+	// Truncated is set when GROUP_MEMBERS_MAX was reached
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// usernameAttr returns the attribute holding usernames.
+func usernameAttr(cfg *Config) string {
+	if m := usernamePattern.FindStringSubmatch(cfg.UserSearchFilter); m != nil {
+		return m[1]
+	}
+	return "uid"
+}
+
+// GetGroupMembers lists the members of group, given as DN or name. With
+// nested, member groups are expanded recursively; otherwise they are
+// returned in Groups.
+func (c *LDAPClient) GetGroupMembers(ctx context.Context, group string, nested bool) (*GroupMembers, error) {
+	root, err := c.resolveGroup(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	out := &GroupMembers{Group: groupSummary(c.cfg, root), Members: []GroupMember{}}
+	seen := map[string]bool{strings.ToLower(root.DN): true}
+	if err := c.collectMembers(ctx, root, nested, seen, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resolveGroup finds a group by DN, or by GroupNameAttr below GroupBase.
+func (c *LDAPClient) resolveGroup(ctx context.Context, group string) (*ldap.Entry, error) {
+	attrs := []string{c.cfg.GroupNameAttr, "description", attrMember, attrUniqueMember, attrMemberUID}
+	var req *ldap.SearchRequest
+	if dn, err := ldap.ParseDN(group); err == nil && len(dn.RDNs) > 1 {
+		// 只接受 GroupBase 之下的组 DN，不能借此读取目录中的任意条目
+		base, err := ldap.ParseDN(c.cfg.GroupBase())
+		if err != nil || !base.AncestorOfFold(dn) {
+			return nil, NewLDAPError(ErrGroupNotFound, "group not found")
+		}
+		req = ldap.NewSearchRequest(group, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(c.cfg.RequestTimeout.Seconds()), false,
+			c.cfg.SearchGroupFilter, attrs, nil)
+	} else {
+		filter := fmt.Sprintf("(&%s(%s=%s))", c.cfg.SearchGroupFilter, c.cfg.GroupNameAttr, ldap.EscapeFilter(group))
+		req = ldap.NewSearchRequest(c.cfg.GroupBase(), ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(c.cfg.RequestTimeout.Seconds()), false,
+			filter, attrs, nil)
+	}
+	spanCtx, end := traceLDAP(ctx, opGroupSearch, attribute.String("ldap.group", group))
+	res, err := c.search(spanCtx, req)
+	end(err)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(res.Entries) == 0) {
+		return nil, NewLDAPError(ErrGroupNotFound, "group not found")
+	}
+	if err != nil {
+		return nil, groupSearchError(ctx, err)
+	}
+	if len(res.Entries) > 1 {
+		return nil, NewLDAPError(ErrGroupNotFound, "group name is ambiguous, use the DN")
+	}
+	return res.Entries[0], nil
+}
+
+// collectMembers adds the members of group to out. seen holds the DNs of
+// groups already visited, so membership cycles terminate, and of members
+// already listed.
+func (c *LDAPClient) collectMembers(ctx context.Context, group *ldap.Entry, nested bool, seen map[string]bool, out *GroupMembers) error {
+	dns, err := c.memberDNs(ctx, group)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(dns); start += memberBatchSize {
+		if c.membersFull(out) {
+			return nil
+		}
+		batch := dns[start:min(start+memberBatchSize, len(dns))]
+		ents, err := c.readMembers(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for i, ent := range ents {
+			if c.membersFull(out) {
+				return nil
+			}
+			if ent == nil {
+				log.Debug().Str("dn", batch[i]).Str("group", group.DN).Msg("skipping dangling group member")
+				continue
+			}
+			// 嵌套展开时同一用户可能经由多个子组到达，只列出一次
+			key := strings.ToLower(ent.DN)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if !isGroup(ent) {
+				out.Members = append(out.Members, c.groupMember(ent))
+				continue
+			}
+			if !nested {
+				out.Groups = append(out.Groups, groupSummary(c.cfg, ent))
+				continue
+			}
+			if err := c.collectMembers(ctx, ent, nested, seen, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	// posixGroup lists usernames rather than DNs
+	for _, uid := range group.GetAttributeValues(attrMemberUID) {
+		if c.membersFull(out) {
+			return nil
+		}
+		dn, attrs, err := c.findUser(ctx, uid, c.cfg.LookupAttributes)
+		if GetLDAPErrorCode(err) == ErrUserNotFound {
+			log.Debug().Str("memberUid", uid).Str("group", group.DN).Msg("skipping unknown memberUid")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if seen[strings.ToLower(dn)] {
+			continue
+		}
+		seen[strings.ToLower(dn)] = true
+		out.Members = append(out.Members, GroupMember{DN: dn, Username: uid, Attributes: attrs})
+	}
+	return nil
+}
+
+func (c *LDAPClient) membersFull(out *GroupMembers) bool {
+	if c.cfg.GroupMembersMax > 0 && len(out.Members) >= c.cfg.GroupMembersMax {
+		out.Truncated = true
+		return true
+	}
+	return false
+}
+
+// memberDNs returns the member and uniqueMember values of group. AD returns
+// at most MaxValRange (1500 by default) values per read as
+// "member;range=0-1499"; the remaining chunks are fetched until the range
+// ends in "*".
+func (c *LDAPClient) memberDNs(ctx context.Context, group *ldap.Entry) ([]string, error) {
+	dns := append(group.GetAttributeValues(attrMember), group.GetAttributeValues(attrUniqueMember)...)
+	ent := group
+	for {
+		next := -1
+		for _, a := range ent.Attributes {
+			m := rangePattern.FindStringSubmatch(a.Name)
+			if m == nil {
+				continue
+			}
+			dns = append(dns, a.Values...)
+			if m[2] != "*" {
+				hi, _ := strconv.Atoi(m[2])
+				next = hi + 1
+			}
+		}
+		if next < 0 {
+			return dns, nil
+		}
+		req := ldap.NewSearchRequest(group.DN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(c.cfg.RequestTimeout.Seconds()), false,
+			"(objectClass=*)", []string{fmt.Sprintf("member;range=%d-*", next)}, nil)
+		spanCtx, end := traceLDAP(ctx, opGroupSearch, attribute.String("ldap.group", group.DN))
+		res, err := c.search(spanCtx, req)
+		end(err)
+		if err != nil {
+			return nil, groupSearchError(ctx, err)
+		}
+		if len(res.Entries) == 0 {
+			return dns, nil
+		}
+		ent = res.Entries[0]
+	}
+}
+
+// readMembers reads the member entries dns with one OR filter on dnAttr
+// below their closest common ancestor. The result is aligned with dns;
+// members that do not exist are nil. DNs without a common ancestor are read
+// one at a time.
+func (c *LDAPClient) readMembers(ctx context.Context, dns []string) ([]*ldap.Entry, error) {
+	base := commonAncestor(dns)
+	if len(dns) == 1 || base == "" {
+		return c.readEach(ctx, dns)
+	}
+	var filter strings.Builder
+	filter.WriteString("(|")
+	for _, dn := range dns {
+		fmt.Fprintf(&filter, "(%s=%s)", dnAttr(c.cfg), ldap.EscapeFilter(dn))
+	}
+	filter.WriteString(")")
+	req := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, len(dns), int(c.cfg.RequestTimeout.Seconds()), false,
+		filter.String(), c.memberAttrs(), nil)
+	spanCtx, end := traceLDAP(ctx, opSearch, attribute.Int("ldap.members", len(dns)))
+	res, err := c.search(spanCtx, req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		// 公共祖先不在本服务器上，退回逐条读取
+		end(nil)
+		return c.readEach(ctx, dns)
+	}
+	end(err)
+	if err != nil {
+		return nil, groupSearchError(ctx, err)
+	}
+	byDN := make(map[string]*ldap.Entry, len(res.Entries))
+	for _, ent := range res.Entries {
+		byDN[dnKey(ent.DN)] = ent
+	}
+	out := make([]*ldap.Entry, len(dns))
+	for i, dn := range dns {
+		out[i] = byDN[dnKey(dn)]
+	}
+	return out, nil
+}
+
+// readEach reads dns one base read at a time, stopping at the request
+// deadline.
+func (c *LDAPClient) readEach(ctx context.Context, dns []string) ([]*ldap.Entry, error) {
+	out := make([]*ldap.Entry, len(dns))
+	for i, dn := range dns {
+		if err := ctx.Err(); err != nil {
+			return nil, groupSearchError(ctx, err)
+		}
+		ent, err := c.readMember(ctx, dn)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = ent
+	}
+	return out, nil
+}
+
+// readMember reads a member entry, or returns nil if it does not exist.
+func (c *LDAPClient) readMember(ctx context.Context, dn string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(c.cfg.RequestTimeout.Seconds()), false,
+		"(objectClass=*)", c.memberAttrs(), nil)
+	spanCtx, end := traceLDAP(ctx, opSearch)
+	res, err := c.search(spanCtx, req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		end(nil)
+		return nil, nil
+	}
+	end(err)
+	if err != nil {
+		return nil, groupSearchError(ctx, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, nil
+	}
+	return res.Entries[0], nil
+}
+
+func (c *LDAPClient) memberAttrs() []string {
+	return append([]string{"objectClass", usernameAttr(c.cfg), c.cfg.GroupNameAttr, "description", attrMember, attrUniqueMember, attrMemberUID}, c.cfg.LookupAttributes...)
+}
+
+// dnAttr returns the attribute that holds an entry's own DN and can be used
+// in filters: distinguishedName on AD, the entryDN operational attribute on
+// OpenLDAP.
+func dnAttr(cfg *Config) string {
+	if cfg.DirectoryType == DirectoryAD {
+		return "distinguishedName"
+	}
+	return "entryDN"
+}
+
+// commonAncestor returns the longest DN suffix shared by all of dns, or ""
+// if they share none or one does not parse.
+func commonAncestor(dns []string) string {
+	var common []*ldap.RelativeDN
+	for i, s := range dns {
+		dn, err := ldap.ParseDN(s)
+		if err != nil {
+			return ""
+		}
+		if i == 0 {
+			common = dn.RDNs
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dn.RDNs) && common[len(common)-1-n].EqualFold(dn.RDNs[len(dn.RDNs)-1-n]) {
+			n++
+		}
+		common = common[len(common)-n:]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	return (&ldap.DN{RDNs: common}).String()
+}
+
+// dnKey normalises dn for case-insensitive comparison.
+func dnKey(dn string) string {
+	if parsed, err := ldap.ParseDN(dn); err == nil {
+		return strings.ToLower(parsed.String())
+	}
+	return strings.ToLower(dn)
+}
+
+func isGroup(ent *ldap.Entry) bool {
+	for _, oc := range ent.GetAttributeValues("objectClass") {
+		for _, g := range groupObjectClasses {
+			if strings.EqualFold(oc, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *LDAPClient) groupMember(ent *ldap.Entry) GroupMember {
+	attrs := map[string]string{}
+	for _, a := range c.cfg.LookupAttributes {
+		if v := ent.GetAttributeValue(a); v != "" {
+			attrs[a] = v
+		}
+	}
+	return GroupMember{DN: ent.DN, Username: ent.GetAttributeValue(usernameAttr(c.cfg)), Attributes: attrs}
+}
+
+type GroupMembersResponse struct {
+	Ok bool `json:"ok"`
+	*GroupMembers
+	Error string `json:"error,omitempty"`
+}
+
+// GET /v1/groups/{group}/members
+func GroupMembersHandler(app *App) http.HandlerFunc {
+	// 大组的列表可能占用连接长达 GROUP_MEMBERS_TIMEOUT，使用查询专用的连接池，不影响 /v1/auth
+	cfg, pool := app.cfg, app.cursors.pool
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := mux.Vars(r)["group"]
+		nested := r.URL.Query().Get("nested") == "true" || r.URL.Query().Get("nested") == "1"
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.GroupMembersTimeout)
+		defer cancel()
+		// 大组的列表可能超过 HTTP_WRITE_TIMEOUT，为本请求单独放宽写超时
+		if cfg.HTTPWriteTimeout > 0 && cfg.GroupMembersTimeout > cfg.HTTPWriteTimeout {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(cfg.GroupMembersTimeout + cfg.HTTPWriteTimeout)); err != nil {
+				log.Warn().Err(err).Msg("failed to extend write deadline for group member listing")
+			}
+		}
+
+		client, err := pool.Get(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get ldap connection")
+			respondJSON(w, http.StatusInternalServerError, GroupMembersResponse{Ok: false, Error: "ldap_client_error"})
+			return
+		}
+		defer pool.Put(client)
+
+		members, err := client.GetGroupMembers(ctx, group, nested)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if GetLDAPErrorCode(err) == ErrGroupNotFound {
+				status = http.StatusNotFound
+			}
+			respondJSON(w, status, GroupMembersResponse{Ok: false, Error: authOutcome(err, ErrSearchFailed)})
+			return
+		}
+		log.Debug().Str("caller", requestCaller(r)).Str("group", members.Group.DN).Int("members", len(members.Members)).Msg("group members listed")
+		respondJSON(w, http.StatusOK, GroupMembersResponse{Ok: true, GroupMembers: members})
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/gorilla/mux"
+)
+
+// fakeDirectory serves base reads, equality searches and AD ranged member
+// reads from a map of entries.
+type fakeDirectory map[string]*ldap.Entry
+
+var equalityFilter = regexp.MustCompile(`\((cn|uid)=([^()*]+)\)\)*$`)
+
+// dnFilter matches the terms of a batched member read.
+var dnFilter = regexp.MustCompile(`\((?:entryDN|distinguishedName)=([^()]+)\)`)
+
+func (d fakeDirectory) add(dn string, attrs map[string][]string) {
+	d[strings.ToLower(dn)] = ldap.NewEntry(dn, attrs)
+}
+
+func (d fakeDirectory) search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if req.Scope == ldap.ScopeBaseObject {
+		ent, ok := d[strings.ToLower(req.BaseDN)]
+		if !ok {
+			return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))
+		}
+		if len(req.Attributes) == 1 && strings.HasPrefix(req.Attributes[0], "member;range=") {
+			return &ldap.SearchResult{Entries: []*ldap.Entry{d.rangeChunk(ent, req.Attributes[0])}}, nil
+		}
+		if ranged := ent.GetAttributeValues("member;range=0-1"); len(ranged) > 0 {
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(ent.DN, map[string][]string{
+				"objectClass": ent.GetAttributeValues("objectClass"), "cn": ent.GetAttributeValues("cn"), "member;range=0-1": ranged,
+			})}}, nil
+		}
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ent}}, nil
+	}
+	res := &ldap.SearchResult{}
+	if terms := dnFilter.FindAllStringSubmatch(req.Filter, -1); terms != nil {
+		for _, t := range terms {
+			if ent, ok := d[strings.ToLower(t[1])]; ok {
+				res.Entries = append(res.Entries, ent)
+			}
+		}
+		return res, nil
+	}
+	m := equalityFilter.FindStringSubmatch(req.Filter)
+	for _, ent := range d {
+		if m != nil && ent.GetAttributeValue(m[1]) == m[2] {
+			res.Entries = append(res.Entries, ent)
+		}
+	}
+	return res, nil
+}
+
+// rangeChunk returns the chunk of the "big" group requested as
+// "member;range=<lo>-*", two values at a time.
+func (d fakeDirectory) rangeChunk(ent *ldap.Entry, attr string) *ldap.Entry {
+	all := ent.GetAttributeValues("allMembers")
+	lo := strings.TrimSuffix(strings.TrimPrefix(attr, "member;range="), "-*")
+	switch lo {
+	case "2":
+		return ldap.NewEntry(ent.DN, map[string][]string{"member;range=2-3": all[2:4]})
+	default:
+		return ldap.NewEntry(ent.DN, map[string][]string{"member;range=4-*": all[4:]})
+	}
+}
+
+func membersTestDirectory() fakeDirectory {
+	d := fakeDirectory{}
+	for _, u := range []string{"alice", "bob", "u0", "u1", "u2", "u3", "u4"} {
+		d.add("uid="+u+",ou=people,dc=example,dc=com", map[string][]string{"objectClass": {"inetOrgPerson"}, "uid": {u}, "cn": {strings.ToUpper(u)}})
+	}
+	d.add("cn=staff,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"staff"},
+		"member": {"uid=alice,ou=people,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com", "uid=ghost,ou=people,dc=example,dc=com"},
+	})
+	d.add("cn=admins,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"admins"},
+		"member": {"uid=bob,ou=people,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+	})
+	var big []string
+	for i := 0; i < 5; i++ {
+		big = append(big, "uid=u"+string(rune('0'+i))+",ou=people,dc=example,dc=com")
+	}
+	d.add("cn=big,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"group"}, "cn": {"big"}, "member;range=0-1": big[:2], "allMembers": big,
+	})
+	d.add("cn=posix,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"posixGroup"}, "cn": {"posix"}, "memberUid": {"alice", "nobody"},
+	})
+	return d
+}
+
+func membersTestClient() *LDAPClient {
+	d := membersTestDirectory()
+	cfg := &Config{
+		UserSearchBase:    "dc=example,dc=com",
+		UserSearchFilter:  "(uid=%s)",
+		GroupNameAttr:     "cn",
+		SearchGroupFilter: "(objectClass=*)",
+		LookupAttributes:  []string{"cn"},
+	}
+	return &LDAPClient{cfg: cfg, conn: &fakeConn{searchFn: d.search}}
+}
+
+func memberNames(m *GroupMembers) []string {
+	var out []string
+	for _, u := range m.Members {
+		out = append(out, u.Username)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestGetGroupMembers(t *testing.T) {
+	c := membersTestClient()
+
+	m, err := c.GetGroupMembers(context.Background(), "staff", false)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"alice"}) {
+		t.Errorf("direct members = %v", got)
+	}
+	if len(m.Groups) != 1 || m.Groups[0].Name != "admins" {
+		t.Errorf("member groups = %+v", m.Groups)
+	}
+	if m.Members[0].Attributes["cn"] != "ALICE" {
+		t.Errorf("attributes = %v", m.Members[0].Attributes)
+	}
+
+	// nested expansion follows admins and stops at the staff <-> admins cycle
+	m, err = c.GetGroupMembers(context.Background(), "cn=staff,ou=groups,dc=example,dc=com", true)
+	if err != nil {
+		t.Fatalf("GetGroupMembers nested: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("nested members = %v", got)
+	}
+	if len(m.Groups) != 0 {
+		t.Errorf("expanded groups listed: %+v", m.Groups)
+	}
+}
+
+func TestGetGroupMembersDeduplicates(t *testing.T) {
+	d := membersTestDirectory()
+	// alice is a direct member and also reached through staff and team
+	d.add("cn=team,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"team"},
+		"member": {"uid=alice,ou=people,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+	})
+	d.add("cn=all,ou=groups,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"all"},
+		"member":    {"uid=alice,ou=people,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com", "cn=team,ou=groups,dc=example,dc=com"},
+		"memberUid": {"alice"},
+	})
+	c := membersTestClient()
+	c.conn = &fakeConn{searchFn: d.search}
+
+	m, err := c.GetGroupMembers(context.Background(), "all", true)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("members = %v, want each user once", got)
+	}
+}
+
+func TestGetGroupMembersRangeRetrieval(t *testing.T) {
+	m, err := membersTestClient().GetGroupMembers(context.Background(), "big", false)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"u0", "u1", "u2", "u3", "u4"}) {
+		t.Errorf("members = %v", got)
+	}
+}
+
+func TestGetGroupMembersPosixAndLimits(t *testing.T) {
+	c := membersTestClient()
+	m, err := c.GetGroupMembers(context.Background(), "posix", false)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"alice"}) {
+		t.Errorf("memberUid members = %v", got)
+	}
+
+	c.cfg.GroupMembersMax = 3
+	m, err = c.GetGroupMembers(context.Background(), "big", false)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if len(m.Members) != 3 || !m.Truncated {
+		t.Errorf("expected 3 members and truncated, got %d, %v", len(m.Members), m.Truncated)
+	}
+
+	if _, err := c.GetGroupMembers(context.Background(), "missing", false); GetLDAPErrorCode(err) != ErrGroupNotFound {
+		t.Errorf("unknown group: err = %v", err)
+	}
+	if _, err := c.GetGroupMembers(context.Background(), "cn=missing,dc=example,dc=com", false); GetLDAPErrorCode(err) != ErrGroupNotFound {
+		t.Errorf("unknown group DN: err = %v", err)
+	}
+}
+
+func TestGetGroupMembersScope(t *testing.T) {
+	c := membersTestClient()
+	c.cfg.GroupSearchBase = "ou=groups,dc=example,dc=com"
+	c.cfg.SearchGroupFilter = "(objectClass=groupOfNames)"
+	var reqs []*ldap.SearchRequest
+	search := c.conn.(*fakeConn).searchFn
+	c.conn.(*fakeConn).searchFn = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		reqs = append(reqs, req)
+		return search(req)
+	}
+
+	// a DN outside LDAP_GROUP_BASE is not read at all
+	if _, err := c.GetGroupMembers(context.Background(), "uid=alice,ou=people,dc=example,dc=com", false); GetLDAPErrorCode(err) != ErrGroupNotFound {
+		t.Errorf("DN outside group base: err = %v", err)
+	}
+	if len(reqs) != 0 {
+		t.Errorf("DN outside group base was searched: %+v", reqs[0])
+	}
+
+	m, err := c.GetGroupMembers(context.Background(), "CN=staff,OU=groups,DC=example,DC=com", false)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberNames(m); !slices.Equal(got, []string{"alice"}) {
+		t.Errorf("members = %v", got)
+	}
+	// one read of the group and one batched read of its three members
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 searches, got %d", len(reqs))
+	}
+	if reqs[0].Filter != c.cfg.SearchGroupFilter {
+		t.Errorf("group DN read with filter %q", reqs[0].Filter)
+	}
+	if reqs[1].BaseDN != "dc=example,dc=com" || strings.Count(reqs[1].Filter, "(entryDN=") != 3 {
+		t.Errorf("member batch: base %q, filter %q", reqs[1].BaseDN, reqs[1].Filter)
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	for _, tc := range []struct {
+		dns  []string
+		want string
+	}{
+		{[]string{"uid=a,ou=people,dc=example,dc=com", "CN=g,OU=groups,DC=Example,DC=com"}, "dc=example,dc=com"},
+		{[]string{"uid=a,ou=people,dc=example,dc=com", "uid=b,ou=people,dc=example,dc=com"}, "ou=people,dc=example,dc=com"},
+		{[]string{"uid=a,dc=example,dc=com", "uid=b,dc=example,dc=org"}, ""},
+		{[]string{"uid=a,dc=example,dc=com", "not a dn"}, ""},
+	} {
+		if got := commonAncestor(tc.dns); got != tc.want {
+			t.Errorf("commonAncestor(%v) = %q, want %q", tc.dns, got, tc.want)
+		}
+	}
+}
+
+func TestUsernameAttr(t *testing.T) {
+	for filter, want := range map[string]string{
+		"(uid=%s)": "uid",
+		"(&(objectClass=user)(sAMAccountName=%s))": "sAMAccountName",
+		"(|(mail=%s)(uid=%s))":                     "mail",
+		"(cn=*)":                                   "uid",
+	} {
+		if got := usernameAttr(&Config{UserSearchFilter: filter}); got != want {
+			t.Errorf("usernameAttr(%s) = %s, want %s", filter, got, want)
+		}
+	}
+}
+
+func TestGroupMembersHandler(t *testing.T) {
+	d := membersTestDirectory()
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) { fc.searchFn = d.search })
+	app.cfg.GroupNameAttr = "cn"
+	app.cfg.SearchGroupFilter = "(objectClass=*)"
+	app.cfg.LookupAttributes = []string{"cn"}
+	app.cfg.GroupMembersTimeout = app.cfg.RequestTimeout
+	app.cursors = newCursorStore(app.cfg, app.pool)
+	// 成员列表使用查询连接池，不占用认证连接池
+	app.pool = nil
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/groups/{group}/members", GroupMembersHandler(app)).Methods("GET")
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/v1/groups/staff/members?nested=true")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"bob"`) || !strings.Contains(w.Body.String(), `"group":{"dn":"cn=staff`) {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if w := get("/v1/groups/missing/members"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "group_not_found") {
+		t.Errorf("unknown group: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+// deadlineRecorder records write deadlines set through
+// http.ResponseController.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (r *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	r.deadline = t
+	return nil
+}
+
+func TestGroupMembersHandlerWriteDeadline(t *testing.T) {
+	d := membersTestDirectory()
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) { fc.searchFn = d.search })
+	app.cfg.GroupNameAttr = "cn"
+	app.cfg.SearchGroupFilter = "(objectClass=*)"
+	app.cfg.LookupAttributes = []string{"cn"}
+	app.cfg.LookupAPIKeys = []string{"lookup-key"}
+	app.cfg.HTTPWriteTimeout = time.Second
+	app.cfg.GroupMembersTimeout = time.Minute
+	app.cursors = newCursorStore(app.cfg, app.pool)
+
+	// 经过 newRouter 的全部中间件，确认写超时确实被延长
+	r := httptest.NewRequest(http.MethodGet, "/v1/groups/staff/members", nil)
+	r.Header.Set("Authorization", "Bearer lookup-key")
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	start := time.Now()
+	newRouter(app).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if w.deadline.Before(start.Add(time.Minute)) {
+		t.Errorf("write deadline = %v, want at least GROUP_MEMBERS_TIMEOUT from now", w.deadline)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ldapsvc"
+
+// Auth outcomes that are not an LDAPErrorCode
+const (
+	outcomeSuccess        = "success"
+	outcomeInvalidRequest = "invalid_request"
+	outcomeInternalError  = "internal_error"
+)
+
+// LDAP operations timed by ldapOperationDuration
+const (
+	opDial           = "dial"
+	opStartTLS       = "starttls"
+	opServiceBind    = "service_bind"
+	opSearch         = "search"
+	opGroupSearch    = "group_search"
+	opUserBind       = "user_bind"
+	opPasswordChange = "password_change"
+	opPasswordReset  = "password_reset"
+	opUnlock         = "unlock"
+)
+
+var (
+	authAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_attempts_total",
+		Help:      "Authentication attempts by outcome.",
+	}, []string{"outcome"})
+
+	passwordChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "password_changes_total",
+		Help:      "Password change attempts by outcome.",
+	}, []string{"outcome"})
+
+	ldapOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ldap_operation_duration_seconds",
+		Help:      "Latency of LDAP operations.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation", "status"})
+
+	serverDialFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ldap_server_dial_failures_total",
+		Help:      "Failed connection attempts per LDAP server.",
+	}, []string{"server"})
+
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by result (success, rejected, unchanged).",
+	}, []string{"result"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+)
+
+// observeLDAP records the duration of an LDAP operation started at start.
+func observeLDAP(op string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	ldapOperationDuration.WithLabelValues(op, status).Observe(time.Since(start).Seconds())
+}
+
+// authOutcome maps an error from the LDAP layer to an auth_attempts_total
+// outcome label.
+func authOutcome(err error, fallback LDAPErrorCode) string {
+	if code := GetLDAPErrorCode(err); code != "" {
+		return string(code)
+	}
+	return string(fallback)
+}
+
+// registerPoolMetrics exposes connection pool gauges read from stats.
+func registerPoolMetrics(stats func() PoolStats) {
+	gauge := func(name, help string, value func(PoolStats) int) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+	gauge("pool_open_connections", "Open LDAP connections, idle and in use.", func(s PoolStats) int { return s.Open })
+	gauge("pool_idle_connections", "Idle LDAP connections.", func(s PoolStats) int { return s.Idle })
+	gauge("pool_in_use_connections", "LDAP connections currently checked out.", func(s PoolStats) int { return s.InUse })
+	gauge("pool_max_open_connections", "Maximum LDAP connections checked out at once.", func(s PoolStats) int { return s.MaxOpen })
+}
+
+// inFlightMiddleware tracks the number of in-flight HTTP requests.
+func inFlightMiddleware(next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(httpInFlight, next)
+}
+
+// GET /metrics
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuthOutcome(t *testing.T) {
+	if got := authOutcome(NewLDAPError(ErrUserNotFound, "user not found"), ErrSearchFailed); got != "user_not_found" {
+		t.Errorf("expected LDAP error code as outcome, got %s", got)
+	}
+	if got := authOutcome(errors.New("plain"), ErrSearchFailed); got != "search_failed" {
+		t.Errorf("expected fallback outcome, got %s", got)
+	}
+}
+
+func TestObserveLDAP(t *testing.T) {
+	before := testutil.CollectAndCount(ldapOperationDuration)
+	observeLDAP("test_op", time.Now(), errors.New("boom"))
+	if after := testutil.CollectAndCount(ldapOperationDuration); after != before+1 {
+		t.Errorf("expected a new series for test_op, got %d -> %d", before, after)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	cfg := testPoolConfig()
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	a, _ := p.Get(context.Background())
+	b, _ := p.Get(context.Background())
+	p.Put(b)
+
+	got := p.Stats()
+	want := PoolStats{Open: 2, Idle: 1, InUse: 1, MaxOpen: 2}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	p.Put(a)
+}
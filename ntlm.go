@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// ntlmBinder is implemented by *ldap.Conn. NTLM binds are not part of the
+// ldap.Client interface.
+type ntlmBinder interface {
+	NTLMBind(domain, username, password string) error
+	NTLMBindWithHash(domain, username, hash string) error
+}
+
+// splitDomainUser splits "DOMAIN\user". Without a backslash the domain is
+// empty and NTLM takes it from the server's challenge.
+func splitDomainUser(name string) (domain, user string) {
+	if domain, user, ok := strings.Cut(name, `\`); ok {
+		return domain, user
+	}
+	return "", name
+}
+
+// ntlmUser reports whether username is "DOMAIN\user" for one of
+// LDAP_USER_NTLM_DOMAINS, whose users are authenticated with an NTLM bind.
+// Other domains are not accepted: the user is looked up by name alone in
+// the domain's base (see ntlmUserBase), so an account of another domain
+// could otherwise be authenticated as a local user of the same name.
+func (c *Config) ntlmUser(username string) (domain, user string, ok bool) {
+	domain, user, found := strings.Cut(username, `\`)
+	if !found || user == "" || strings.Contains(user, `\`) {
+		return "", "", false
+	}
+	if !slices.ContainsFunc(c.UserNTLMDomains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+		return "", "", false
+	}
+	return domain, user, true
+}
+
+// ntlmUserBase returns the search base for users of domain: its entry in
+// LDAP_USER_NTLM_BASES, or LDAP_USER_BASE without one.
+func (c *Config) ntlmUserBase(domain string) string {
+	for d, base := range c.UserNTLMBases {
+		if strings.EqualFold(d, domain) {
+			return base
+		}
+	}
+	return c.UserSearchBase
+}
+
+// validNTHash reports whether h is an NT hash: 16 bytes in hex.
+func validNTHash(h string) bool {
+	b, err := hex.DecodeString(h)
+	return err == nil && len(b) == 16
+}
+
+// ntlmBind binds the connection with NTLM, using hash instead of password
+// when set.
+func (c *LDAPClient) ntlmBind(domain, user, password, hash string) error {
+	b, ok := c.conn.(ntlmBinder)
+	if !ok {
+		return NewLDAPError(ErrBindFailed, "connection does not support NTLM bind")
+	}
+	if hash != "" {
+		return b.NTLMBindWithHash(domain, user, hash)
+	}
+	return b.NTLMBind(domain, user, password)
+}
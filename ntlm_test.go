@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestNTLMUser(t *testing.T) {
+	cfg := &Config{UserNTLMDomains: []string{"CORP"}}
+	for name, want := range map[string]string{
+		`corp\alice`:                       "alice",
+		`CORP\alice`:                       "alice",
+		`OTHER\alice`:                      "",
+		`alice`:                            "",
+		`CORP\`:                            "",
+		`CORP\a\b`:                         "",
+		`CN=Smith\, John,OU=Users,DC=corp`: "",
+	} {
+		if _, user, ok := cfg.ntlmUser(name); user != want || ok != (want != "") {
+			t.Errorf("ntlmUser(%q) = %q, %v, want %q", name, user, ok, want)
+		}
+	}
+}
+
+func TestBindServiceNTLM(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{BindMethod: BindMethodNTLM, BindDN: `CORP\svc-ldap`, BindPassword: "pw"}, conn: fc}
+	if err := c.bindService(t.Context()); err != nil {
+		t.Fatalf("bindService: %v", err)
+	}
+	c.cfg = &Config{BindMethod: BindMethodNTLM, BindDN: "svc-ldap", BindNTHash: "8846f7eaee8fb117ad06bdd830b7586c"}
+	var passwords []string
+	fc.bindFn = func(_, password string) error {
+		passwords = append(passwords, password)
+		return nil
+	}
+	if err := c.bindService(t.Context()); err != nil {
+		t.Fatalf("bindService: %v", err)
+	}
+	if !slices.Equal(fc.binds, []string{`NTLM CORP\svc-ldap`, `NTLM \svc-ldap`}) || passwords[0] != "hash:8846f7eaee8fb117ad06bdd830b7586c" {
+		t.Errorf("binds = %q, passwords = %q", fc.binds, passwords)
+	}
+
+	c.conn = struct{ ldap.Client }{}
+	if err := c.bindService(t.Context()); GetLDAPErrorCode(err) != ErrBindFailed {
+		t.Errorf("err = %v, want bind_failed", err)
+	}
+}
+
+func TestAuthHandlerNTLMUserBind(t *testing.T) {
+	var filters []string
+	app, d := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.searchFn = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			filters = append(filters, req.Filter)
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("CN=Alice,DC=corp,DC=example,DC=com", nil)}}, nil
+		}
+		fc.bindFn = func(username, _ string) error {
+			if strings.HasPrefix(username, "CN=") {
+				return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("simple bind should not be used"))
+			}
+			return nil
+		}
+	})
+	app.cfg.UserNTLMDomains = []string{"CORP"}
+
+	w := httptest.NewRecorder()
+	AuthHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(`{"username":"corp\\alice","password":"pw"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if !slices.Equal(filters, []string{"(uid=alice)"}) {
+		t.Errorf("filters = %q, want a search without the domain", filters)
+	}
+	if binds := d.conns[0].binds; !slices.Contains(binds, `NTLM corp\alice`) {
+		t.Errorf("binds = %q", binds)
+	}
+
+	// 未配置的域按普通用户名处理
+	w = httptest.NewRecorder()
+	AuthHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(`{"username":"other\\alice","password":"pw"}`)))
+	if w.Code != http.StatusUnauthorized || filters[len(filters)-1] != `(uid=other\5calice)` {
+		t.Errorf("status %d, filters %q", w.Code, filters)
+	}
+}
+
+func TestAuthHandlerNTLMDomainBase(t *testing.T) {
+	var bases []string
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.searchFn = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			if strings.HasPrefix(req.Filter, "(uid=") {
+				bases = append(bases, req.BaseDN)
+			}
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("CN=Alice,"+req.BaseDN, nil)}}, nil
+		}
+	})
+	app.cfg.UserNTLMDomains = []string{"CORP", "LAB"}
+	app.cfg.UserNTLMBases = map[string]string{"CORP": "OU=Users,DC=corp,DC=example,DC=com", "lab": "OU=Users,DC=lab,DC=example,DC=com"}
+
+	for _, name := range []string{`corp\\alice`, `LAB\\alice`} {
+		w := httptest.NewRecorder()
+		AuthHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(`{"username":"`+name+`","password":"pw"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", name, w.Code, w.Body)
+		}
+	}
+	if !slices.Equal(bases, []string{"OU=Users,DC=corp,DC=example,DC=com", "OU=Users,DC=lab,DC=example,DC=com"}) {
+		t.Errorf("search bases = %q, want each domain's own base", bases)
+	}
+}
+
+func TestValidateNTLMBases(t *testing.T) {
+	t.Setenv("LDAP_DIRECTORY_TYPE", DirectoryAD)
+	t.Setenv("LDAP_USER_NTLM_DOMAINS", "CORP,LAB")
+	t.Setenv("LDAP_USER_NTLM_BASES", "CORP=ou=users,dc=corp,dc=example,dc=com; LAB=ou=users,dc=lab,dc=example,dc=com")
+	c, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("separate bases rejected: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		bases map[string]string
+		want  string
+	}{
+		"shared base": {nil, "LDAP_USER_NTLM_BASES: needs a search base for CORP"},
+		"one missing": {map[string]string{"corp": "dc=corp,dc=example,dc=com"}, "LDAP_USER_NTLM_BASES: needs a search base for LAB"},
+		"nested":      {map[string]string{"CORP": "dc=example,dc=com", "LAB": "dc=lab,dc=example,dc=com"}, "LDAP_USER_NTLM_BASES: search bases of CORP and LAB overlap"},
+		"unknown":     {map[string]string{"CORP": "dc=corp,dc=com", "LAB": "dc=lab,dc=com", "OTHER": "dc=other,dc=com"}, "LDAP_USER_NTLM_BASES: OTHER is not listed"},
+		"invalid":     {map[string]string{"CORP": "dc=corp,dc=com", "LAB": "not a dn"}, `LDAP_USER_NTLM_BASES: "not a dn" for LAB is not a valid DN`},
+	} {
+		c.UserNTLMBases = tc.bases
+		err := c.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestValidateBindMethodNTLM(t *testing.T) {
+	c := defaultConfig()
+	c.BindMethod = BindMethodNTLM
+	c.BindNTHash = "not-a-hash"
+	c.BindPassword = "pw"
+	c.UserNTLMDomains = []string{"CORP"}
+	err := c.Validate()
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	for _, want := range []string{
+		"LDAP_BIND_DN: required for ntlm",
+		"LDAP_BIND_METHOD: ntlm requires LDAP_DIRECTORY_TYPE=ad",
+		"LDAP_BIND_NT_HASH: must be 32 hexadecimal characters",
+		"LDAP_BIND_PASSWORD: ntlm needs exactly one",
+		"LDAP_USER_NTLM_DOMAINS: requires LDAP_DIRECTORY_TYPE=ad",
+	} {
+		if !slices.ContainsFunc(problems, func(p string) bool { return strings.HasPrefix(p, want) }) {
+			t.Errorf("missing problem %q in:\n%s", want, strings.Join(problems, "\n"))
+		}
+	}
+	if strings.Contains(err.Error(), "not-a-hash") {
+		t.Error("NT hash leaked into error")
+	}
+
+	c = defaultConfig()
+	c.DirectoryType = DirectoryAD
+	c.BindMethod = BindMethodNTLM
+	c.BindDN = `CORP\svc-ldap`
+	c.BindNTHash = "8846F7EAEE8FB117AD06BDD830B7586C"
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid NTLM config rejected: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Directory types for LDAP_DIRECTORY_TYPE
+const (
+	DirectoryOpenLDAP = "openldap" // RFC 3062 Password Modify extended operation
+	DirectoryAD       = "ad"       // unicodePwd delete/add modify
+)
+
+// ChangePassword changes the password of userDN from oldPassword to
+// newPassword. On OpenLDAP the connection must be bound as the user; on
+// Active Directory it may also be bound as the service account, since the
+// directory itself verifies the old password in the delete half of the
+// modify.
+func (c *LDAPClient) ChangePassword(ctx context.Context, userDN, oldPassword, newPassword string) error {
+	err := c.exec(ctx, opPasswordChange, func() error {
+		if c.cfg.DirectoryType == DirectoryAD {
+			req := ldap.NewModifyRequest(userDN, nil)
+			req.Delete("unicodePwd", []string{adPassword(oldPassword)})
+			req.Add("unicodePwd", []string{adPassword(newPassword)})
+			return c.conn.Modify(req)
+		}
+		_, err := c.conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, oldPassword, newPassword))
+		return err
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("userDN", userDN).Msg("password change rejected")
+		return passwordChangeError(err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password for userDN without knowing the old one.
+// The connection must be bound as the service account, which needs the
+// directory's reset password right. With mustChange the user has to change
+// the password at next logon (pwdLastSet=0 on AD, pwdReset=TRUE with
+// OpenLDAP ppolicy).
+func (c *LDAPClient) ResetPassword(ctx context.Context, userDN, newPassword string, mustChange bool) error {
+	err := c.exec(ctx, opPasswordReset, func() error {
+		if c.cfg.DirectoryType == DirectoryAD {
+			req := ldap.NewModifyRequest(userDN, nil)
+			req.Replace("unicodePwd", []string{adPassword(newPassword)})
+			if mustChange {
+				req.Replace("pwdLastSet", []string{"0"})
+			}
+			return c.conn.Modify(req)
+		}
+		if _, err := c.conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, "", newPassword)); err != nil {
+			return err
+		}
+		if !mustChange {
+			return nil
+		}
+		req := ldap.NewModifyRequest(userDN, nil)
+		req.Replace("pwdReset", []string{"TRUE"})
+		return c.conn.Modify(req)
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("userDN", userDN).Msg("password reset rejected")
+		return passwordChangeError(err)
+	}
+	return nil
+}
+
+// Unlock clears the directory's lockout of userDN (lockoutTime on AD,
+// pwdAccountLockedTime with OpenLDAP ppolicy). Unlocking an account that is
+// not locked succeeds.
+func (c *LDAPClient) Unlock(ctx context.Context, userDN string) error {
+	err := c.exec(ctx, opUnlock, func() error {
+		req := ldap.NewModifyRequest(userDN, nil)
+		if c.cfg.DirectoryType == DirectoryAD {
+			req.Replace("lockoutTime", []string{"0"})
+		} else {
+			req.Delete("pwdAccountLockedTime", nil)
+		}
+		err := c.conn.Modify(req)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		if GetLDAPErrorCode(err) != "" {
+			return err
+		}
+		return NewLDAPErrorWithCause(ErrUnlockFailed, "unlock failed", err)
+	}
+	return nil
+}
+
+// exec runs a modifying operation bounded by ctx and traced as op. A
+// timeout is returned as ErrConnectionTimeout; other errors are returned
+// unchanged for the caller to map.
+func (c *LDAPClient) exec(ctx context.Context, op string, fn func() error) error {
+	_, end := traceLDAP(ctx, op)
+	ch := make(chan error, 1)
+	go func() { ch <- fn() }()
+
+	select {
+	case <-ctx.Done():
+		c.broken = true
+		end(ctx.Err())
+		return NewLDAPErrorWithCause(ErrConnectionTimeout, op+" timeout", ctx.Err())
+	case err := <-ch:
+		end(err)
+		return err
+	}
+}
+
+// adPassword encodes a password for unicodePwd: the quoted password in
+// UTF-16LE.
+func adPassword(password string) string {
+	u := utf16.Encode([]rune(`"` + password + `"`))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return string(b)
+}
+
+// passwordChangeError maps a rejected password change to an LDAPError.
+// Constraint violations are narrowed down from the diagnostic message where
+// the directory says why (OpenLDAP ppolicy); AD only reports 0000052D and is
+// reported as a generic policy violation.
+func passwordChangeError(err error) error {
+	if GetLDAPErrorCode(err) != "" {
+		return err
+	}
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return NewLDAPErrorWithCause(ErrPasswordChangeFailed, "password change failed", err)
+	}
+	switch ldapErr.ResultCode {
+	case ldap.LDAPResultConstraintViolation:
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "history"):
+			return NewLDAPErrorWithCause(ErrPasswordInHistory, "new password was used before", err)
+		case strings.Contains(msg, "too young"):
+			return NewLDAPErrorWithCause(ErrPasswordTooYoung, "password was changed too recently", err)
+		}
+		return NewLDAPErrorWithCause(ErrPasswordPolicy, "new password does not meet the password policy", err)
+	case ldap.LDAPResultInvalidCredentials:
+		return NewLDAPErrorWithCause(ErrInvalidCredentials, "old password is incorrect", err)
+	case ldap.LDAPResultInsufficientAccessRights, ldap.LDAPResultUnwillingToPerform:
+		// AD refuses unicodePwd changes over unencrypted connections with unwillingToPerform
+		return NewLDAPErrorWithCause(ErrPasswordChangeDenied, "directory refused the password change", err)
+	}
+	return NewLDAPErrorWithCause(ErrPasswordChangeFailed, "password change failed", err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestADPassword(t *testing.T) {
+	if got, want := adPassword("pä"), "\"\x00p\x00\xe4\x00\"\x00"; got != want {
+		t.Errorf("adPassword = %q, want %q", got, want)
+	}
+}
+
+func TestPasswordChangeError(t *testing.T) {
+	tests := []struct {
+		code uint16
+		msg  string
+		want LDAPErrorCode
+	}{
+		{ldap.LDAPResultConstraintViolation, "Password fails quality checking policy", ErrPasswordPolicy},
+		{ldap.LDAPResultConstraintViolation, "Password is in history of old passwords", ErrPasswordInHistory},
+		{ldap.LDAPResultConstraintViolation, "Password is too young to change", ErrPasswordTooYoung},
+		{ldap.LDAPResultConstraintViolation, "0000052D: Constraint violation - check_password_restrictions", ErrPasswordPolicy},
+		{ldap.LDAPResultInvalidCredentials, "", ErrInvalidCredentials},
+		{ldap.LDAPResultUnwillingToPerform, "0000001F: SvcErr: DSID-031A12D2, problem 5003 (WILL_NOT_PERFORM)", ErrPasswordChangeDenied},
+		{ldap.LDAPResultInsufficientAccessRights, "", ErrPasswordChangeDenied},
+		{ldap.LDAPResultOther, "", ErrPasswordChangeFailed},
+	}
+	for _, tt := range tests {
+		err := passwordChangeError(ldap.NewError(tt.code, errors.New(tt.msg)))
+		if got := GetLDAPErrorCode(err); got != tt.want {
+			t.Errorf("%d %q: code = %q, want %q", tt.code, tt.msg, got, tt.want)
+		}
+	}
+	if got := GetLDAPErrorCode(passwordChangeError(errors.New("connection reset"))); got != ErrPasswordChangeFailed {
+		t.Errorf("non-LDAP error: code = %q", got)
+	}
+}
+
+func TestChangePasswordOpenLDAP(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryOpenLDAP}, conn: fc}
+	if err := c.ChangePassword(context.Background(), "uid=alice,dc=example,dc=com", "old", "new"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if len(fc.passwordModifies) != 1 || len(fc.modifies) != 0 {
+		t.Fatalf("expected one password modify, got %d modifies and %d password modifies", len(fc.modifies), len(fc.passwordModifies))
+	}
+	req := fc.passwordModifies[0]
+	if req.UserIdentity != "uid=alice,dc=example,dc=com" || req.OldPassword != "old" || req.NewPassword != "new" {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestChangePasswordAD(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryAD}, conn: fc}
+	if err := c.ChangePassword(context.Background(), "CN=Alice,DC=example,DC=com", "old", "new"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if len(fc.modifies) != 1 {
+		t.Fatalf("expected one modify, got %d", len(fc.modifies))
+	}
+	ch := fc.modifies[0].Changes
+	if len(ch) != 2 || ch[0].Operation != ldap.DeleteAttribute || ch[1].Operation != ldap.AddAttribute {
+		t.Fatalf("expected delete then add, got %+v", ch)
+	}
+	if ch[0].Modification.Type != "unicodePwd" || ch[0].Modification.Vals[0] != adPassword("old") || ch[1].Modification.Vals[0] != adPassword("new") {
+		t.Errorf("unexpected changes %+v", ch)
+	}
+}
+
+func passwordChangeTestApp(t *testing.T, setup func(*fakeConn)) (*App, *fakeDialer) {
+	cfg := testPoolConfig()
+	cfg.UserSearchFilter = "(uid=%s)"
+	cfg.DirectoryType = DirectoryAD
+	d := &fakeDialer{setup: func(fc *fakeConn) {
+		fc.searchFn = func(*ldap.SearchRequest) (*ldap.SearchResult, error) {
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("CN=Alice,DC=example,DC=com", nil)}}, nil
+		}
+		setup(fc)
+	}}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	t.Cleanup(p.Close)
+	return &App{cfg: cfg, pool: p}, d
+}
+
+func postPasswordChange(app *App) (*httptest.ResponseRecorder, AuthResponse) {
+	body := `{"username":"alice","old_password":"old","new_password":"new"}`
+	w := httptest.NewRecorder()
+	PasswordChangeHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/password/change", strings.NewReader(body)))
+	var resp AuthResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestPasswordChangeHandlerADExpiredPassword(t *testing.T) {
+	app, d := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.bindFn = func(username, _ string) error {
+			if strings.HasPrefix(username, "CN=Alice") {
+				return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 532, v4563"))
+			}
+			return nil
+		}
+	})
+
+	w, resp := postPasswordChange(app)
+	if w.Code != http.StatusOK || !resp.Ok {
+		t.Fatalf("status %d, response %+v", w.Code, resp)
+	}
+	fc := d.conns[0]
+	if len(fc.modifies) != 1 {
+		t.Fatalf("expected the change to be submitted, got %d modifies", len(fc.modifies))
+	}
+	// the modify must run as the service account, not the rejected user
+	if last := fc.binds[len(fc.binds)-1]; last != app.cfg.BindDN {
+		t.Errorf("last bind = %q, want service account", last)
+	}
+}
+
+func TestPasswordChangeHandlerPolicyViolation(t *testing.T) {
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.modifyErr = ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("0000052D: Constraint violation"))
+	})
+
+	w, resp := postPasswordChange(app)
+	if w.Code != http.StatusBadRequest || resp.Error != string(ErrPasswordPolicy) {
+		t.Fatalf("status %d, response %+v", w.Code, resp)
+	}
+}
+
+func TestPasswordChangeHandlerWrongPassword(t *testing.T) {
+	app, d := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.bindFn = func(username, _ string) error {
+			if strings.HasPrefix(username, "CN=Alice") {
+				return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563"))
+			}
+			return nil
+		}
+	})
+
+	w, resp := postPasswordChange(app)
+	if w.Code != http.StatusUnauthorized || resp.Error != string(ErrInvalidCredentials) {
+		t.Fatalf("status %d, response %+v", w.Code, resp)
+	}
+	if n := len(d.conns[0].modifies); n != 0 {
+		t.Errorf("password change submitted after failed bind: %d modifies", n)
+	}
+}
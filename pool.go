@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// LDAPPool keeps a bounded set of service-bound LDAP connections so that
+// requests do not pay a TCP/TLS handshake and service bind on every login.
+type LDAPPool struct {
+	cfg  *Config
+	dial func(ctx context.Context) (*LDAPClient, error)
+
+	// sem bounds the number of connections checked out at the same time.
+	sem chan struct{}
+
+	mu      sync.Mutex
+	idle    []*LDAPClient
+	numOpen int
+	closed  bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewLDAPPool creates a pool dialing through the given server set and starts
+// the background maintainer that retires aged connections and keeps
+// PoolMinIdle connections warm.
+func NewLDAPPool(cfg *Config, servers *ServerSet) *LDAPPool {
+	return newLDAPPool(cfg, servers.Dial)
+}
+
+func newLDAPPool(cfg *Config, dial func(ctx context.Context) (*LDAPClient, error)) *LDAPPool {
+	maxOpen := cfg.PoolMaxOpen
+	if maxOpen <= 0 {
+		maxOpen = 1
+	}
+	p := &LDAPPool{
+		cfg:  cfg,
+		dial: dial,
+		sem:  make(chan struct{}, maxOpen),
+		stop: make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.maintain()
+	return p
+}
+
+// Get borrows a connection bound as the service account. Callers must hand
+// it back with Put.
+func (p *LDAPPool) Get(ctx context.Context) (c *LDAPClient, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "ldap.pool.get")
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, NewLDAPErrorWithCause(ErrConnectionTimeout, "timed out waiting for a pooled connection", ctx.Err())
+	}
+
+	for {
+		c = p.popIdle()
+		if c == nil {
+			break
+		}
+		if p.expired(c) {
+			p.discard(c)
+			continue
+		}
+		if p.cfg.PoolHealthCheck {
+			if err := c.ping(ctx); err != nil {
+				log.Debug().Err(err).Msg("discarding unhealthy pooled connection")
+				p.discard(c)
+				continue
+			}
+		}
+		return c, nil
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, NewLDAPError(ErrConnectionFailed, "connection pool closed")
+	}
+	p.numOpen++
+	p.mu.Unlock()
+
+	c, err = p.dial(ctx)
+	if err != nil {
+		p.mu.Lock()
+		p.numOpen--
+		p.mu.Unlock()
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put returns a borrowed connection. Connections that were bound as a user
+// are re-bound as the service account first; anything that cannot be
+// safely reused is closed.
+func (p *LDAPPool) Put(c *LDAPClient) {
+	if c == nil {
+		return
+	}
+	defer func() { <-p.sem }()
+
+	if c.broken || c.conn == nil || c.conn.IsClosing() || p.expired(c) {
+		p.discard(c)
+		return
+	}
+	ctx, cancel := p.opContext()
+	defer cancel()
+	if err := c.rebind(ctx); err != nil {
+		log.Warn().Err(err).Msg("failed to restore service bind, dropping connection")
+		p.discard(c)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.cfg.PoolMaxIdle {
+		p.mu.Unlock()
+		p.discard(c)
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.mu.Unlock()
+}
+
+// PoolStats is a snapshot of pool usage.
+type PoolStats struct {
+	Open    int // idle and checked-out connections
+	Idle    int
+	InUse   int
+	MaxOpen int
+}
+
+func (p *LDAPPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Open:    p.numOpen,
+		Idle:    len(p.idle),
+		InUse:   len(p.sem),
+		MaxOpen: cap(p.sem),
+	}
+}
+
+// Close stops the maintainer and closes all idle connections. Connections
+// still checked out are closed when they are returned.
+func (p *LDAPPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+	for _, c := range idle {
+		p.discard(c)
+	}
+}
+
+func (p *LDAPPool) popIdle() *LDAPClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.idle)
+	if n == 0 {
+		return nil
+	}
+	// LIFO: the most recently used connection is the most likely to be alive
+	c := p.idle[n-1]
+	p.idle = p.idle[:n-1]
+	return c
+}
+
+func (p *LDAPPool) expired(c *LDAPClient) bool {
+	return p.cfg.PoolMaxConnAge > 0 && time.Since(c.createdAt) > p.cfg.PoolMaxConnAge
+}
+
+// opContext bounds work the pool does outside a request, such as re-binds
+// and pre-dials, by LDAP_REQUEST_TIMEOUT so a hung server cannot block it.
+func (p *LDAPPool) opContext() (context.Context, context.CancelFunc) {
+	if p.cfg.RequestTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), p.cfg.RequestTimeout)
+}
+
+func (p *LDAPPool) discard(c *LDAPClient) {
+	c.Close()
+	p.mu.Lock()
+	p.numOpen--
+	p.mu.Unlock()
+}
+
+func (p *LDAPPool) maintain() {
+	defer p.wg.Done()
+	interval := 30 * time.Second
+	if p.cfg.PoolMaxConnAge > 0 && p.cfg.PoolMaxConnAge/2 < interval {
+		interval = p.cfg.PoolMaxConnAge / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.fillIdle()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.retireExpired()
+			p.fillIdle()
+		}
+	}
+}
+
+func (p *LDAPPool) retireExpired() {
+	p.mu.Lock()
+	var keep, drop []*LDAPClient
+	for _, c := range p.idle {
+		if p.expired(c) {
+			drop = append(drop, c)
+		} else {
+			keep = append(keep, c)
+		}
+	}
+	p.idle = keep
+	p.mu.Unlock()
+
+	for _, c := range drop {
+		p.discard(c)
+	}
+}
+
+// fillIdle tops the idle list up to PoolMinIdle without exceeding PoolMaxOpen.
+func (p *LDAPPool) fillIdle() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.cfg.PoolMinIdle || p.numOpen >= cap(p.sem) {
+			p.mu.Unlock()
+			return
+		}
+		p.numOpen++
+		p.mu.Unlock()
+
+		ctx, cancel := p.opContext()
+		c, err := p.dial(ctx)
+		cancel()
+		if err != nil {
+			p.mu.Lock()
+			p.numOpen--
+			p.mu.Unlock()
+			log.Warn().Err(err).Msg("failed to pre-dial pooled LDAP connection")
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			p.discard(c)
+			return
+		}
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+	}
+}
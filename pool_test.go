@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// fakeConn implements ldap.Client for tests; methods that are not overridden
+// panic through the nil embedded interface.
+type fakeConn struct {
+	ldap.Client
+
+	mu      sync.Mutex
+	closed  bool
+	binds   []string
+	bindErr error
+	bindFn  func(username, password string) error
+	// bindControls are returned as response controls of SimpleBind
+	bindControls []ldap.Control
+	simpleBinds  []*ldap.SimpleBindRequest
+	searchErr    error
+	searchFn     func(*ldap.SearchRequest) (*ldap.SearchResult, error)
+
+	modifyErr        error
+	modifies         []*ldap.ModifyRequest
+	passwordModifies []*ldap.PasswordModifyRequest
+}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeConn) IsClosing() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *fakeConn) Bind(username, password string) error {
+	f.mu.Lock()
+	f.binds = append(f.binds, username)
+	bindFn, bindErr := f.bindFn, f.bindErr
+	f.mu.Unlock()
+	if bindFn != nil {
+		return bindFn(username, password)
+	}
+	return bindErr
+}
+
+func (f *fakeConn) SimpleBind(req *ldap.SimpleBindRequest) (*ldap.SimpleBindResult, error) {
+	f.mu.Lock()
+	f.simpleBinds = append(f.simpleBinds, req)
+	f.mu.Unlock()
+	err := f.Bind(req.Username, req.Password)
+	return &ldap.SimpleBindResult{Controls: f.bindControls}, err
+}
+
+func (f *fakeConn) UnauthenticatedBind(username string) error {
+	return f.Bind(username, "")
+}
+
+// ExternalBind is recorded as a bind of "EXTERNAL".
+func (f *fakeConn) ExternalBind() error {
+	return f.Bind("EXTERNAL", "")
+}
+
+// NTLM binds are recorded as "NTLM DOMAIN\user".
+func (f *fakeConn) NTLMBind(domain, username, password string) error {
+	return f.Bind("NTLM "+domain+`\`+username, password)
+}
+
+func (f *fakeConn) NTLMBindWithHash(domain, username, hash string) error {
+	return f.Bind("NTLM "+domain+`\`+username, "hash:"+hash)
+}
+
+func (f *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if f.searchFn != nil {
+		return f.searchFn(req)
+	}
+	if f.searchErr != nil {
+		return nil, f.searchErr
+	}
+	return &ldap.SearchResult{}, nil
+}
+
+func (f *fakeConn) SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	return f.Search(req)
+}
+
+func (f *fakeConn) Modify(req *ldap.ModifyRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.modifies = append(f.modifies, req)
+	return f.modifyErr
+}
+
+func (f *fakeConn) PasswordModify(req *ldap.PasswordModifyRequest) (*ldap.PasswordModifyResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.passwordModifies = append(f.passwordModifies, req)
+	return &ldap.PasswordModifyResult{}, f.modifyErr
+}
+
+type fakeDialer struct {
+	mu    sync.Mutex
+	conns []*fakeConn
+	// setup optionally configures each new connection
+	setup func(*fakeConn)
+}
+
+func (d *fakeDialer) dial(cfg *Config) func(ctx context.Context) (*LDAPClient, error) {
+	return func(ctx context.Context) (*LDAPClient, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		fc := &fakeConn{}
+		if d.setup != nil {
+			d.setup(fc)
+		}
+		d.conns = append(d.conns, fc)
+		return &LDAPClient{cfg: cfg, conn: fc, createdAt: time.Now()}, nil
+	}
+}
+
+func (d *fakeDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+func testPoolConfig() *Config {
+	return &Config{
+		BindDN:          "cn=svc,dc=example,dc=com",
+		BindPassword:    "secret",
+		PoolMaxOpen:     2,
+		PoolMaxIdle:     2,
+		PoolHealthCheck: true,
+		RequestTimeout:  time.Second,
+	}
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	cfg := testPoolConfig()
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		c, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		p.Put(c)
+	}
+	if d.count() != 1 {
+		t.Errorf("expected 1 dial, got %d", d.count())
+	}
+}
+
+func TestPoolBoundsCheckedOutConnections(t *testing.T) {
+	cfg := testPoolConfig()
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	a, _ := p.Get(context.Background())
+	b, _ := p.Get(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(ctx); GetLDAPErrorCode(err) != ErrConnectionTimeout {
+		t.Fatalf("expected ErrConnectionTimeout when pool exhausted, got %v", err)
+	}
+
+	p.Put(a)
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get after Put: %v", err)
+	}
+	if c != a {
+		t.Error("expected the returned connection to be reused")
+	}
+	p.Put(b)
+	p.Put(c)
+}
+
+func TestPoolRebindsAfterUserBind(t *testing.T) {
+	cfg := testPoolConfig()
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	c, _ := p.Get(context.Background())
+	if err := c.AuthenticateWithDN(context.Background(), "uid=alice,dc=example,dc=com", "pw"); err != nil {
+		t.Fatalf("AuthenticateWithDN: %v", err)
+	}
+	p.Put(c)
+
+	fc := d.conns[0]
+	want := []string{"uid=alice,dc=example,dc=com", cfg.BindDN}
+	if len(fc.binds) != len(want) || fc.binds[0] != want[0] || fc.binds[1] != want[1] {
+		t.Errorf("expected binds %v, got %v", want, fc.binds)
+	}
+	if c.userBound {
+		t.Error("expected userBound to be cleared after rebind")
+	}
+}
+
+func TestPoolDiscardsFailedRebind(t *testing.T) {
+	cfg := testPoolConfig()
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	c, _ := p.Get(context.Background())
+	_ = c.AuthenticateWithDN(context.Background(), "uid=alice,dc=example,dc=com", "pw")
+	d.conns[0].bindErr = errors.New("boom")
+	p.Put(c)
+
+	if !d.conns[0].IsClosing() {
+		t.Error("expected connection to be closed after failed rebind")
+	}
+	c2, _ := p.Get(context.Background())
+	defer p.Put(c2)
+	if d.count() != 2 {
+		t.Errorf("expected a fresh dial, got %d dials", d.count())
+	}
+}
+
+func TestPoolRebindTimeout(t *testing.T) {
+	cfg := testPoolConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	c, _ := p.Get(context.Background())
+	_ = c.AuthenticateWithDN(context.Background(), "uid=alice,dc=example,dc=com", "pw")
+	hang := make(chan struct{})
+	defer close(hang)
+	d.conns[0].mu.Lock()
+	d.conns[0].bindFn = func(string, string) error { <-hang; return nil }
+	d.conns[0].mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.Put(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Put blocked on a hung re-bind")
+	}
+	if !d.conns[0].IsClosing() {
+		t.Error("expected connection to be closed after re-bind timeout")
+	}
+	if s := p.Stats(); s.Open != 0 || s.Idle != 0 {
+		t.Errorf("stats = %+v, want no open connections", s)
+	}
+}
+
+func TestPoolDiscardsExpiredAndUnhealthy(t *testing.T) {
+	cfg := testPoolConfig()
+	cfg.PoolMaxConnAge = time.Hour
+	d := &fakeDialer{}
+	p := newLDAPPool(cfg, d.dial(cfg))
+	defer p.Close()
+
+	c, _ := p.Get(context.Background())
+	c.createdAt = time.Now().Add(-2 * time.Hour)
+	p.Put(c)
+	if !d.conns[0].IsClosing() {
+		t.Error("expected expired connection to be closed on Put")
+	}
+
+	c, _ = p.Get(context.Background())
+	p.Put(c)
+	d.conns[1].searchErr = errors.New("connection reset")
+	c, _ = p.Get(context.Background())
+	defer p.Put(c)
+	if d.count() != 3 {
+		t.Errorf("expected unhealthy connection to be replaced, got %d dials", d.count())
+	}
+}
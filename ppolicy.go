@@ -0,0 +1,59 @@
+package main
+
+import (
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// PasswordPolicy is the state reported by the draft-behera password policy
+// response control (OpenLDAP ppolicy overlay) on a user bind.
+type PasswordPolicy struct {
+	// ExpiresIn is the number of seconds until the password expires, when
+	// the directory warns about it
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// GraceLoginsRemaining is set when the bind used a grace login with an
+	// already expired password
+	GraceLoginsRemaining *int64 `json:"grace_logins_remaining,omitempty"`
+	// Error is the policy error as an error code, e.g. password_expired
+	Error string `json:"error,omitempty"`
+}
+
+// usePasswordPolicy reports whether user binds carry the ppolicy request
+// control.
+func (c *LDAPClient) usePasswordPolicy() bool {
+	return c.cfg.PasswordPolicyControl && c.cfg.DirectoryType == DirectoryOpenLDAP
+}
+
+// parsePasswordPolicy extracts the ppolicy response control, or returns nil
+// if the directory did not send one.
+func parsePasswordPolicy(controls []ldap.Control) *PasswordPolicy {
+	ctrl, ok := ldap.FindControl(controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy)
+	if !ok {
+		return nil
+	}
+	p := &PasswordPolicy{}
+	if ctrl.Expire >= 0 {
+		p.ExpiresIn = ctrl.Expire
+	}
+	if ctrl.Grace >= 0 {
+		grace := ctrl.Grace
+		p.GraceLoginsRemaining = &grace
+	}
+	if code := policyErrorCode(ctrl.Error); code != "" {
+		p.Error = string(code)
+	}
+	return p
+}
+
+// policyErrorCode maps the ppolicy errors that concern a bind to error
+// codes; the others only occur on password modification.
+func policyErrorCode(e int8) LDAPErrorCode {
+	switch e {
+	case ldap.BeheraPasswordExpired:
+		return ErrPasswordExpired
+	case ldap.BeheraAccountLocked:
+		return ErrAccountLocked
+	case ldap.BeheraChangeAfterReset:
+		return ErrPasswordMustChange
+	}
+	return ""
+}
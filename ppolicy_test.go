@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func ppolicyControl(expire, grace int64, e int8) *ldap.ControlBeheraPasswordPolicy {
+	return &ldap.ControlBeheraPasswordPolicy{Expire: expire, Grace: grace, Error: e}
+}
+
+func TestParsePasswordPolicy(t *testing.T) {
+	if p := parsePasswordPolicy(nil); p != nil {
+		t.Errorf("expected nil without control, got %+v", p)
+	}
+
+	p := parsePasswordPolicy([]ldap.Control{ppolicyControl(3600, -1, -1)})
+	if p == nil || p.ExpiresIn != 3600 || p.GraceLoginsRemaining != nil || p.Error != "" {
+		t.Errorf("expiry warning: got %+v", p)
+	}
+
+	p = parsePasswordPolicy([]ldap.Control{ppolicyControl(-1, 0, -1)})
+	if p == nil || p.GraceLoginsRemaining == nil || *p.GraceLoginsRemaining != 0 {
+		t.Errorf("last grace login: got %+v", p)
+	}
+
+	for e, want := range map[int8]LDAPErrorCode{
+		ldap.BeheraPasswordExpired:       ErrPasswordExpired,
+		ldap.BeheraAccountLocked:         ErrAccountLocked,
+		ldap.BeheraChangeAfterReset:      ErrPasswordMustChange,
+		ldap.BeheraPasswordModNotAllowed: "",
+	} {
+		if p := parsePasswordPolicy([]ldap.Control{ppolicyControl(-1, -1, e)}); p.Error != string(want) {
+			t.Errorf("error %d: got %q, want %q", e, p.Error, want)
+		}
+	}
+}
+
+func ppolicyClient(fc *fakeConn) *LDAPClient {
+	return &LDAPClient{cfg: &Config{DirectoryType: DirectoryOpenLDAP, PasswordPolicyControl: true}, conn: fc}
+}
+
+func TestAuthenticateWithPolicy(t *testing.T) {
+	fc := &fakeConn{bindControls: []ldap.Control{ppolicyControl(600, -1, -1)}}
+	p, err := ppolicyClient(fc).AuthenticateWithPolicy(context.Background(), "uid=alice,dc=example,dc=com", "pw")
+	if err != nil {
+		t.Fatalf("AuthenticateWithPolicy: %v", err)
+	}
+	if p == nil || p.ExpiresIn != 600 {
+		t.Errorf("policy = %+v, want expires_in 600", p)
+	}
+	if len(fc.simpleBinds) != 1 || ldap.FindControl(fc.simpleBinds[0].Controls, ldap.ControlTypeBeheraPasswordPolicy) == nil {
+		t.Error("ppolicy request control not sent")
+	}
+}
+
+func TestAuthenticateWithPolicyErrors(t *testing.T) {
+	// locked account: bind fails with the reason in the control
+	fc := &fakeConn{
+		bindErr:      ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("")),
+		bindControls: []ldap.Control{ppolicyControl(-1, -1, ldap.BeheraAccountLocked)},
+	}
+	if _, err := ppolicyClient(fc).AuthenticateWithPolicy(context.Background(), "uid=alice", "pw"); GetLDAPErrorCode(err) != ErrAccountLocked {
+		t.Errorf("locked: err = %v", err)
+	}
+
+	// after an admin reset the bind succeeds but the password must be changed
+	fc = &fakeConn{bindControls: []ldap.Control{ppolicyControl(-1, -1, ldap.BeheraChangeAfterReset)}}
+	if _, err := ppolicyClient(fc).AuthenticateWithPolicy(context.Background(), "uid=alice", "pw"); GetLDAPErrorCode(err) != ErrPasswordMustChange {
+		t.Errorf("change after reset: err = %v", err)
+	}
+}
+
+func TestAuthenticateWithoutPolicyControl(t *testing.T) {
+	fc := &fakeConn{}
+	c := &LDAPClient{cfg: &Config{DirectoryType: DirectoryAD, PasswordPolicyControl: true}, conn: fc}
+	if _, err := c.AuthenticateWithPolicy(context.Background(), "CN=Alice", "pw"); err != nil {
+		t.Fatalf("AuthenticateWithPolicy: %v", err)
+	}
+	if len(fc.simpleBinds) != 0 {
+		t.Error("ppolicy control sent to Active Directory")
+	}
+}
+
+func TestAuthHandlerPasswordPolicy(t *testing.T) {
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.bindControls = []ldap.Control{ppolicyControl(86400, -1, -1)}
+	})
+	app.cfg.DirectoryType = DirectoryOpenLDAP
+	app.cfg.PasswordPolicyControl = true
+
+	w := httptest.NewRecorder()
+	AuthHandler(app)(w, httptest.NewRequest(http.MethodPost, "/v1/auth", strings.NewReader(`{"username":"alice","password":"pw"}`)))
+	var resp AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || resp.PasswordPolicy == nil || resp.PasswordPolicy.ExpiresIn != 86400 {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttle key kinds
+const (
+	ThrottleUser = "user"
+	ThrottleIP   = "ip"
+)
+
+type throttleEntry struct {
+	failures    []time.Time // failed attempts inside the sliding window
+	lockedUntil time.Time
+	inFlight    int // attempts admitted by Check and not yet settled
+}
+
+// throttlePendingRetry is the Retry-After for a key whose attempts in
+// flight could still reach its threshold.
+const throttlePendingRetry = time.Second
+
+// Throttler counts failed logins per username and per client IP over a
+// sliding window, delays repeated failures progressively and locks a key
+// out locally once it reaches its threshold. Thresholds should be set below
+// the directory's own lockout policy so attackers cannot lock real accounts.
+type Throttler struct {
+	cfg *Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]map[string]*throttleEntry // kind -> key -> entry
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewThrottler(cfg *Config) *Throttler {
+	t := &Throttler{
+		cfg: cfg,
+		now: time.Now,
+		entries: map[string]map[string]*throttleEntry{
+			ThrottleUser: {},
+			ThrottleIP:   {},
+		},
+		stop: make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.janitor()
+	return t
+}
+
+// SetConfig applies new thresholds on config reload, keeping the recorded
+// failures and lockouts.
+func (t *Throttler) SetConfig(cfg *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+}
+
+func (t *Throttler) Close() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+func throttleUserKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// Check reports whether the username or IP is locked out and, if so, for how
+// long. Otherwise it reserves the attempt and returns the progressive delay
+// to apply before trying the directory. Reserved attempts count towards the
+// thresholds until they are settled with Success, Failure or Release, so
+// concurrent requests cannot all pass before the first failure is recorded.
+func (t *Throttler) Check(username, ip string) (retryAfter, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	failures, pending := 0, false
+	for _, k := range t.keys(username, ip) {
+		e := t.entries[k.kind][k.key]
+		if e == nil {
+			continue
+		}
+		if wait := e.lockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+		n := len(t.prune(e, now)) + e.inFlight
+		if k.max > 0 && n >= k.max {
+			pending = true
+		}
+		if k.kind == ThrottleUser {
+			failures = n
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, 0
+	}
+	if pending {
+		return throttlePendingRetry, 0
+	}
+	for _, k := range t.keys(username, ip) {
+		t.entry(k.kind, k.key).inFlight++
+	}
+	return 0, t.delayFor(failures)
+}
+
+type throttleKey struct {
+	kind, key string
+	max       int
+}
+
+// keys returns the throttled keys of an attempt with their thresholds.
+func (t *Throttler) keys(username, ip string) []throttleKey {
+	var keys []throttleKey
+	if u := throttleUserKey(username); u != "" {
+		keys = append(keys, throttleKey{ThrottleUser, u, t.cfg.RateLimitUserMaxFailures})
+	}
+	if ip != "" {
+		keys = append(keys, throttleKey{ThrottleIP, ip, t.cfg.RateLimitIPMaxFailures})
+	}
+	return keys
+}
+
+func (t *Throttler) entry(kind, key string) *throttleEntry {
+	e := t.entries[kind][key]
+	if e == nil {
+		e = &throttleEntry{}
+		t.entries[kind][key] = e
+	}
+	return e
+}
+
+// settle ends an attempt reserved by Check.
+func (t *Throttler) settle(kind, key string) {
+	if e := t.entries[kind][key]; e != nil && e.inFlight > 0 {
+		e.inFlight--
+	}
+}
+
+// delayFor doubles the base delay for every previous failure of the user.
+func (t *Throttler) delayFor(failures int) time.Duration {
+	if failures == 0 || t.cfg.RateLimitDelay <= 0 {
+		return 0
+	}
+	d := t.cfg.RateLimitDelay
+	for i := 1; i < failures && d < t.cfg.RateLimitMaxDelay; i++ {
+		d *= 2
+	}
+	if t.cfg.RateLimitMaxDelay > 0 && d > t.cfg.RateLimitMaxDelay {
+		d = t.cfg.RateLimitMaxDelay
+	}
+	return d
+}
+
+// Failure records a failed login and locks out keys that reached their
+// threshold.
+func (t *Throttler) Failure(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, k := range t.keys(username, ip) {
+		t.settle(k.kind, k.key)
+		t.fail(k.kind, k.key, k.max, now)
+	}
+}
+
+func (t *Throttler) fail(kind, key string, max int, now time.Time) {
+	e := t.entry(kind, key)
+	e.failures = append(t.prune(e, now), now)
+	if max > 0 && len(e.failures) >= max {
+		e.lockedUntil = now.Add(t.cfg.RateLimitLockout)
+		e.failures = nil
+	}
+}
+
+// Success clears the user's failure history. The IP history is kept so a
+// single valid account cannot be used to reset an address that is guessing
+// other accounts.
+func (t *Throttler) Success(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.settle(ThrottleIP, ip)
+	key := throttleUserKey(username)
+	e := t.entries[ThrottleUser][key]
+	if e == nil {
+		return
+	}
+	t.settle(ThrottleUser, key)
+	if e.inFlight > 0 {
+		// 其他并发尝试仍在进行，保留占位
+		e.failures, e.lockedUntil = nil, time.Time{}
+		return
+	}
+	delete(t.entries[ThrottleUser], key)
+}
+
+// Release settles an attempt that ended without checking the password,
+// e.g. on a directory error, without counting it as a failure.
+func (t *Throttler) Release(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range t.keys(username, ip) {
+		t.settle(k.kind, k.key)
+	}
+}
+
+// prune drops failures that fell out of the sliding window.
+func (t *Throttler) prune(e *throttleEntry, now time.Time) []time.Time {
+	cutoff := now.Add(-t.cfg.RateLimitWindow)
+	i := 0
+	for i < len(e.failures) && !e.failures[i].After(cutoff) {
+		i++
+	}
+	e.failures = e.failures[i:]
+	return e.failures
+}
+
+// Lockout describes the throttling state of one key.
+type Lockout struct {
+	Type        string     `json:"type"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// Lockouts lists keys that are locked out or have recent failures.
+func (t *Throttler) Lockouts() []Lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := []Lockout{}
+	for kind, keys := range t.entries {
+		for key, e := range keys {
+			n := len(t.prune(e, now))
+			locked := e.lockedUntil.After(now)
+			if n == 0 && !locked {
+				continue
+			}
+			l := Lockout{Type: kind, Key: key, Failures: n}
+			if locked {
+				until := e.lockedUntil
+				l.LockedUntil = &until
+			}
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// Clear removes the lockout and failure history of a key. It reports
+// whether the key was known.
+func (t *Throttler) Clear(kind, key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys, ok := t.entries[kind]
+	if !ok {
+		return false
+	}
+	if kind == ThrottleUser {
+		key = throttleUserKey(key)
+	}
+	if _, ok := keys[key]; !ok {
+		return false
+	}
+	delete(keys, key)
+	return true
+}
+
+// janitor periodically forgets keys without recent failures or lockouts.
+func (t *Throttler) janitor() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			now := t.now()
+			for _, keys := range t.entries {
+				for key, e := range keys {
+					if len(t.prune(e, now)) == 0 && !e.lockedUntil.After(now) && e.inFlight == 0 {
+						delete(keys, key)
+					}
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func testThrottler(now *time.Time) *Throttler {
+	return &Throttler{
+		cfg: &Config{
+			RateLimitWindow:          10 * time.Minute,
+			RateLimitUserMaxFailures: 3,
+			RateLimitIPMaxFailures:   5,
+			RateLimitLockout:         15 * time.Minute,
+			RateLimitDelay:           100 * time.Millisecond,
+			RateLimitMaxDelay:        time.Second,
+		},
+		now: func() time.Time { return *now },
+		entries: map[string]map[string]*throttleEntry{
+			ThrottleUser: {},
+			ThrottleIP:   {},
+		},
+	}
+}
+
+func TestThrottlerUserLockout(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+
+	for i := 0; i < 3; i++ {
+		if retry, _ := th.Check("Alice", "10.0.0.1"); retry != 0 {
+			t.Fatalf("attempt %d locked out early", i)
+		}
+		th.Failure("Alice", "10.0.0.1")
+	}
+	retry, _ := th.Check("alice", "10.0.0.2")
+	if retry != 15*time.Minute {
+		t.Fatalf("retryAfter = %v, want 15m (usernames are case-insensitive)", retry)
+	}
+	if retry, _ := th.Check("bob", "10.0.0.1"); retry != 0 {
+		t.Fatalf("other user from same IP locked out: %v", retry)
+	}
+
+	now = now.Add(15 * time.Minute)
+	if retry, _ := th.Check("alice", "10.0.0.1"); retry != 0 {
+		t.Fatalf("still locked after lockout expired: %v", retry)
+	}
+}
+
+func TestThrottlerIPLockout(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+
+	for _, u := range []string{"a", "b", "c", "d", "e"} {
+		th.Failure(u, "10.0.0.1")
+	}
+	if retry, _ := th.Check("f", "10.0.0.1"); retry <= 0 {
+		t.Fatal("IP not locked out after reaching its threshold")
+	}
+	if retry, _ := th.Check("f", "10.0.0.2"); retry != 0 {
+		t.Fatalf("other IP locked out: %v", retry)
+	}
+}
+
+func TestThrottlerSlidingWindow(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+
+	th.Failure("alice", "10.0.0.1")
+	th.Failure("alice", "10.0.0.1")
+	now = now.Add(11 * time.Minute)
+	th.Failure("alice", "10.0.0.1")
+	if retry, _ := th.Check("alice", "10.0.0.1"); retry != 0 {
+		t.Fatalf("failures outside the window counted: locked for %v", retry)
+	}
+}
+
+func TestThrottlerProgressiveDelay(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+	th.cfg.RateLimitUserMaxFailures = 0
+	th.cfg.RateLimitIPMaxFailures = 0
+
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if _, delay := th.Check("alice", "10.0.0.1"); delay != w {
+			t.Fatalf("delay after %d failures = %v, want %v", i, delay, w)
+		}
+		th.Failure("alice", "10.0.0.1")
+	}
+
+	th.Success("alice", "10.0.0.1")
+	if _, delay := th.Check("alice", "10.0.0.1"); delay != 0 {
+		t.Fatalf("delay after success = %v, want 0", delay)
+	}
+}
+
+func TestThrottlerLockoutsAndClear(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+	for i := 0; i < 3; i++ {
+		th.Failure("alice", "10.0.0.1")
+	}
+
+	got := th.Lockouts()
+	if len(got) != 2 {
+		t.Fatalf("Lockouts() = %+v, want ip and user entries", got)
+	}
+	if got[0].Type != ThrottleIP || got[0].Failures != 3 || got[0].LockedUntil != nil {
+		t.Errorf("ip entry = %+v", got[0])
+	}
+	if got[1].Type != ThrottleUser || got[1].Key != "alice" || got[1].LockedUntil == nil {
+		t.Errorf("user entry = %+v", got[1])
+	}
+
+	if !th.Clear(ThrottleUser, "ALICE") {
+		t.Fatal("Clear(user, ALICE) = false")
+	}
+	if th.Clear(ThrottleUser, "alice") {
+		t.Fatal("Clear of an unknown key = true")
+	}
+	if th.Clear("host", "x") {
+		t.Fatal("Clear of an unknown type = true")
+	}
+	if retry, _ := th.Check("alice", "10.0.0.3"); retry != 0 {
+		t.Fatalf("user still locked after Clear: %v", retry)
+	}
+}
+
+func TestThrottlerConcurrentAttempts(t *testing.T) {
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	th := testThrottler(&now)
+	th.cfg.RateLimitDelay = 0
+
+	// 所有请求都在第一次失败记录之前通过 Check
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if retry, _ := th.Check("alice", "10.0.0.1"); retry == 0 {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if admitted != th.cfg.RateLimitUserMaxFailures {
+		t.Fatalf("%d concurrent attempts admitted, want %d", admitted, th.cfg.RateLimitUserMaxFailures)
+	}
+
+	th.Failure("alice", "10.0.0.1")
+	th.Failure("alice", "10.0.0.1")
+	if retry, _ := th.Check("alice", "10.0.0.1"); retry != throttlePendingRetry {
+		t.Fatalf("retryAfter = %v while an attempt is in flight, want %v", retry, throttlePendingRetry)
+	}
+	if retry, _ := th.Check("bob", "10.0.0.2"); retry != 0 {
+		t.Fatalf("unrelated attempt rejected: %v", retry)
+	}
+	// 以其他原因结束的尝试释放占位，不计为失败
+	th.Release("alice", "10.0.0.1")
+	if retry, _ := th.Check("alice", "10.0.0.1"); retry != 0 {
+		t.Fatalf("retryAfter = %v after attempts settled below the threshold", retry)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// secretConfigFields are reported as changed by a reload without their
+// values.
+var secretConfigFields = map[string]bool{
+	"BindPassword":  true,
+	"BindNTHash":    true,
+	"AdminAPIKeys":  true,
+	"LookupAPIKeys": true,
+	"VaultToken":    true,
+}
+
+// restartConfigFields only take effect at startup: they configure the
+// listener, tracing or the log file, which are not rebuilt on reload.
+var restartConfigFields = []string{
+	"ServicePort", "ServiceTLSCert", "ServiceTLSKey", "ServiceTLSClientCA",
+	"HTTPReadTimeout", "HTTPWriteTimeout", "HTTPIdleTimeout",
+	"TracingEnabled", "TracingEndpoint", "TracingServiceName", "TracingSampleRatio",
+	"LogFile", "ConfigWatchInterval", "SecretRefreshInterval",
+}
+
+// generation is everything built from one configuration: the LDAP servers
+// and pool, per-config dependencies and the router. Requests hold a
+// reference while they run so a replaced generation is only torn down once
+// its in-flight requests are done.
+type generation struct {
+	app       *App
+	handler   http.Handler
+	discovery *SRVDiscovery // nil without LDAP_SRV_DOMAIN
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	drained chan struct{}
+}
+
+// newGeneration builds the runtime for cfg. The throttler and, when its
+// settings are unchanged, the auditor are carried over from prev so lockouts
+// and audit files survive a reload.
+func newGeneration(cfg *Config, prev *generation) (_ *generation, err error) {
+	servers := NewServerSet(cfg)
+	g := &generation{drained: make(chan struct{})}
+	if cfg.LDAPSRVDomain != "" {
+		g.discovery = NewSRVDiscovery(cfg, servers)
+		g.discovery.Start()
+	}
+	app := &App{cfg: cfg, pool: NewLDAPPool(cfg, servers)}
+	g.app = app
+	defer func() {
+		if err != nil {
+			g.closeUnshared(prev)
+		}
+	}()
+
+	if prev != nil && sameConfigFields(prev.app.cfg, cfg, "Audit") {
+		app.audit = prev.app.audit
+	} else if app.audit, err = NewAuditor(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.TokenEnabled {
+		if app.tokens, err = NewTokenIssuer(cfg); err != nil {
+			return nil, err
+		}
+	}
+	// 以下步骤不会失败，可以安全地修改与上一代共享的限流器
+	if cfg.RateLimitEnabled {
+		if prev != nil && prev.app.throttle != nil {
+			app.throttle = prev.app.throttle
+			app.throttle.SetConfig(cfg)
+		} else {
+			app.throttle = NewThrottler(cfg)
+		}
+	}
+	if cfg.LookupEnabled() {
+		app.cursors = newCursorStore(cfg, NewLDAPPool(searchPoolConfig(cfg), servers))
+	}
+	g.handler = newRouter(app)
+	return g, nil
+}
+
+// acquire registers a request, failing once the generation is retired.
+func (g *generation) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.retired {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *generation) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.retired && g.active == 0 {
+		close(g.drained)
+	}
+}
+
+// retire stops new requests from entering, waits up to grace for running
+// ones and then closes everything next doesn't share.
+func (g *generation) retire(next *generation, grace time.Duration) {
+	g.mu.Lock()
+	g.retired = true
+	if g.active == 0 {
+		close(g.drained)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-g.drained:
+	case <-time.After(grace):
+		log.Warn().Dur("grace", grace).Msg("requests still running on replaced configuration, closing its connections")
+	}
+	g.closeUnshared(next)
+}
+
+// closeUnshared releases the generation's resources except those reused by
+// other (nil at shutdown).
+func (g *generation) closeUnshared(other *generation) {
+	app := g.app
+	if app.cursors != nil {
+		app.cursors.Close()
+	}
+	// 借出中的连接在归还时关闭
+	app.pool.Close()
+	if g.discovery != nil {
+		g.discovery.Close()
+	}
+	if app.tokens != nil {
+		app.tokens.Close()
+	}
+	if app.audit != nil && (other == nil || other.app.audit != app.audit) {
+		app.audit.Close()
+	}
+	if app.throttle != nil && (other == nil || other.app.throttle != app.throttle) {
+		app.throttle.Close()
+	}
+}
+
+// Reloader serves requests with the current configuration generation and
+// replaces it on SIGHUP or when the config file or a secret file changes.
+type Reloader struct {
+	path    string
+	current atomic.Pointer[generation]
+
+	mu       sync.Mutex // serialises reloads
+	fileHash [sha256.Size]byte
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewReloader builds the first generation from cfg, which was loaded from
+// path ("" without a config file).
+func NewReloader(path string, cfg *Config) (*Reloader, error) {
+	g, err := newGeneration(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{path: path, stop: make(chan struct{})}
+	r.current.Store(g)
+	r.fileHash, _ = hashFiles(r.sources(cfg))
+	return r, nil
+}
+
+// Config returns the active configuration.
+func (r *Reloader) Config() *Config {
+	return r.current.Load().app.cfg
+}
+
+// PoolStats reports the active generation's pool, for the pool gauges.
+func (r *Reloader) PoolStats() PoolStats {
+	return r.current.Load().app.pool.Stats()
+}
+
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for {
+		g := r.current.Load()
+		if g.acquire() {
+			defer g.release()
+			g.handler.ServeHTTP(w, req)
+			return
+		}
+		if r.current.Load() == g {
+			// 已关闭，不再接受请求
+			respondJSON(w, http.StatusServiceUnavailable, map[string]any{"ok": false, "error": "shutting_down"})
+			return
+		}
+		// 刚被替换，重新读取当前代
+	}
+}
+
+// Reload loads and validates the configuration again and, if it differs,
+// swaps in a new generation. An invalid configuration is rejected and the
+// current one keeps serving.
+func (r *Reloader) Reload(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.current.Load()
+	hash, _ := hashFiles(r.sources(old.app.cfg))
+	r.fileHash = hash
+
+	cfg, err := loadValidConfig(r.path)
+	if err != nil {
+		configReloads.WithLabelValues("rejected").Inc()
+		log.Error().Err(err).Str("reason", reason).Msg("configuration reload rejected, keeping current configuration")
+		return err
+	}
+
+	if kept := keepRestartFields(old.app.cfg, cfg); len(kept) > 0 {
+		log.Warn().Strs("fields", kept).Msg("changed settings only take effect after a restart")
+	}
+	changes := configDiff(old.app.cfg, cfg)
+	if len(changes) == 0 {
+		if reason == reloadSecretRefresh {
+			// 定期刷新通常没有变化，不计入 unchanged，避免淹没手动和文件触发的重载
+			log.Debug().Str("reason", reason).Msg("secrets unchanged")
+			return nil
+		}
+		configReloads.WithLabelValues("unchanged").Inc()
+		log.Info().Str("reason", reason).Msg("configuration unchanged")
+		return nil
+	}
+
+	next, err := newGeneration(cfg, old)
+	if err != nil {
+		configReloads.WithLabelValues("rejected").Inc()
+		log.Error().Err(err).Str("reason", reason).Msg("configuration reload rejected, keeping current configuration")
+		return err
+	}
+	r.current.Store(next)
+	zerolog.SetGlobalLevel(parseLogLevel(cfg.LogLevel))
+	configReloads.WithLabelValues("success").Inc()
+	log.Info().Str("reason", reason).Strs("changes", changes).Msg("configuration reloaded")
+
+	go old.retire(next, cfg.ShutdownTimeout)
+	return nil
+}
+
+// Watch reloads whenever the content of the config file or a *_FILE secret
+// changes, checking every interval. It does nothing without such files or
+// an interval.
+func (r *Reloader) Watch(interval time.Duration) {
+	if len(r.sources(r.Config())) == 0 || interval <= 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				hash, err := hashFiles(r.sources(r.Config()))
+				r.mu.Lock()
+				changed := err == nil && hash != r.fileHash
+				r.mu.Unlock()
+				if changed {
+					_ = r.Reload("config file changed")
+				}
+			}
+		}
+	}()
+}
+
+// reloadSecretRefresh is the reason of the periodic reloads started by
+// RefreshSecrets.
+const reloadSecretRefresh = "secret refresh"
+
+// RefreshSecrets reloads every interval so that secrets read from a secret
+// provider follow rotation. It does nothing without an interval or a
+// configured provider.
+func (r *Reloader) RefreshSecrets(interval time.Duration) {
+	if interval <= 0 || !secretProvidersConfigured(r.Config()) {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				_ = r.Reload(reloadSecretRefresh)
+			}
+		}
+	}()
+}
+
+// Close stops watching and tears down the current generation once its
+// requests are done.
+func (r *Reloader) Close() {
+	close(r.stop)
+	r.wg.Wait()
+	g := r.current.Load()
+	g.retire(nil, g.app.cfg.ShutdownTimeout)
+}
+
+// sources lists the files cfg was loaded from: the config file and the
+// *_FILE secrets.
+func (r *Reloader) sources(cfg *Config) []string {
+	var files []string
+	if r.path != "" {
+		files = append(files, r.path)
+	}
+	return append(files, cfg.secretFiles()...)
+}
+
+func hashFiles(paths []string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", path, len(data))
+		h.Write(data)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// configDiff lists the fields that differ between old and new as
+// "key: old -> new", naming fields by their config file key. Secret values
+// are never included.
+func configDiff(old, new *Config) []string {
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	var out []string
+	for i := 0; i < ov.NumField(); i++ {
+		f := ov.Type().Field(i)
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		key := configKey(f)
+		if secretConfigFields[f.Name] {
+			out = append(out, key+": changed")
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s: %v -> %v", key, a, b))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// keepRestartFields copies the startup-only fields of old into new, so the
+// active config reflects what is actually running, and returns the keys of
+// those that were changed.
+func keepRestartFields(old, new *Config) []string {
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	var kept []string
+	for _, name := range restartConfigFields {
+		if !reflect.DeepEqual(ov.FieldByName(name).Interface(), nv.FieldByName(name).Interface()) {
+			f, _ := ov.Type().FieldByName(name)
+			kept = append(kept, configKey(f))
+			nv.FieldByName(name).Set(ov.FieldByName(name))
+		}
+	}
+	return kept
+}
+
+// sameConfigFields reports whether all fields whose name starts with prefix
+// are equal.
+func sameConfigFields(a, b *Config, prefix string) bool {
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < av.NumField(); i++ {
+		if strings.HasPrefix(av.Type().Field(i).Name, prefix) && !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+func configKey(f reflect.StructField) string {
+	if key, _, _ := strings.Cut(f.Tag.Get("yaml"), ","); key != "" {
+		return key
+	}
+	return f.Name
+}
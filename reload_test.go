@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func poolClosed(p *LDAPPool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func rewriteConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestReloader(t *testing.T, content string) (*Reloader, string) {
+	t.Helper()
+	path := writeConfigFile(t, "config.yaml", content)
+	cfg, err := loadValidConfig(path)
+	if err != nil {
+		t.Fatalf("loadValidConfig: %v", err)
+	}
+	r, err := NewReloader(path, cfg)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	t.Cleanup(r.Close)
+	return r, path
+}
+
+const reloadTestConfig = "ldap_urls: [ldap://dc1.example.com]\nlog_file: \"\"\nshutdown_timeout: 1s\n"
+
+func TestReloaderReload(t *testing.T) {
+	r, path := newTestReloader(t, reloadTestConfig)
+	old := r.current.Load()
+
+	if err := r.Reload("test"); err != nil || r.current.Load() != old {
+		t.Fatalf("unchanged reload should keep the generation: %v", err)
+	}
+
+	rewriteConfigFile(t, path, strings.Replace(reloadTestConfig, "]", ", ldap://dc2.example.com]", 1)+"ldap_bind_dn: cn=svc\nldap_bind_password: s3cret\nservice_port: \"9999\"\n")
+	// a request still running on the old generation delays its teardown
+	if !old.acquire() {
+		t.Fatal("acquire failed")
+	}
+	if err := r.Reload("test"); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	cfg := r.Config()
+	if !slices.Equal(cfg.ServerURLs(), []string{"ldap://dc1.example.com", "ldap://dc2.example.com"}) {
+		t.Errorf("ServerURLs = %v", cfg.ServerURLs())
+	}
+	if cfg.ServicePort != "8080" {
+		t.Errorf("ServicePort = %s, restart-only fields should keep their running value", cfg.ServicePort)
+	}
+	if r.current.Load().app.throttle != old.app.throttle {
+		t.Error("throttler should survive a reload")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if poolClosed(old.app.pool) {
+		t.Fatal("old pool closed while a request was running")
+	}
+	old.release()
+	waitFor(t, "old pool to close", func() bool { return poolClosed(old.app.pool) })
+	if poolClosed(r.current.Load().app.pool) {
+		t.Error("new pool closed")
+	}
+}
+
+func TestReloaderRejectsInvalidConfig(t *testing.T) {
+	r, path := newTestReloader(t, reloadTestConfig)
+	old := r.current.Load()
+
+	for _, content := range []string{
+		reloadTestConfig + "ldap_use_ldaps: true\nldap_use_starttls: true\n",
+		reloadTestConfig + "ldap_urlz: x\n",
+	} {
+		rewriteConfigFile(t, path, content)
+		if err := r.Reload("test"); err == nil {
+			t.Errorf("expected rejection, got %v", err)
+		}
+		if r.current.Load() != old || poolClosed(old.app.pool) {
+			t.Error("rejected reload replaced the running generation")
+		}
+	}
+}
+
+func TestReloaderSecretRefreshUnchanged(t *testing.T) {
+	r, _ := newTestReloader(t, reloadTestConfig)
+	unchanged := configReloads.WithLabelValues("unchanged")
+
+	before := testutil.ToFloat64(unchanged)
+	if err := r.Reload(reloadSecretRefresh); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := testutil.ToFloat64(unchanged); got != before {
+		t.Errorf("secret refresh counted as an unchanged reload: %v -> %v", before, got)
+	}
+	if err := r.Reload("SIGHUP"); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := testutil.ToFloat64(unchanged); got != before+1 {
+		t.Errorf("unchanged SIGHUP reload not counted: %v -> %v", before, got)
+	}
+}
+
+func TestReloaderServesCurrentGeneration(t *testing.T) {
+	r, path := newTestReloader(t, reloadTestConfig)
+	get := func(p string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		return w.Code
+	}
+	if code := get("/v1/healthz"); code != http.StatusOK {
+		t.Fatalf("healthz = %d", code)
+	}
+
+	rewriteConfigFile(t, path, reloadTestConfig+"base_path: /ldap\n")
+	if err := r.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	if get("/ldap/v1/healthz") != http.StatusOK || get("/v1/healthz") != http.StatusNotFound {
+		t.Error("routes not rebuilt for the new base path")
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	r, path := newTestReloader(t, reloadTestConfig)
+	r.Watch(10 * time.Millisecond)
+
+	rewriteConfigFile(t, path, reloadTestConfig+"ldap_user_base: ou=people,dc=example,dc=org\n")
+	waitFor(t, "file change reload", func() bool { return r.Config().UserSearchBase == "ou=people,dc=example,dc=org" })
+
+	// an invalid edit is rejected once, not on every poll
+	rewriteConfigFile(t, path, reloadTestConfig+"ldap_pool_max_open: 0\n")
+	time.Sleep(50 * time.Millisecond)
+	if r.Config().PoolMaxOpen == 0 {
+		t.Error("invalid config applied")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	a, b := defaultConfig(), defaultConfig()
+	b.LDAPURLs = []string{"ldap://dc2"}
+	b.BindPassword = "hunter2"
+	b.AdminAPIKeys = []string{"k"}
+	b.RequestTimeout = 3 * time.Second
+
+	got := configDiff(a, b)
+	want := []string{
+		"admin_api_keys: changed",
+		"ldap_bind_password: changed",
+		"ldap_request_timeout: 8s -> 3s",
+		"ldap_urls: [] -> [ldap://dc2]",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("configDiff = %q, want %q", got, want)
+	}
+	if strings.Contains(strings.Join(got, " "), "hunter2") {
+		t.Error("secret leaked into diff")
+	}
+}
+
+func TestKeepRestartFields(t *testing.T) {
+	a, b := defaultConfig(), defaultConfig()
+	b.ServicePort = "9000"
+	b.HTTPWriteTimeout = time.Minute
+	b.LogLevel = "debug"
+
+	kept := keepRestartFields(a, b)
+	if !slices.Equal(kept, []string{"service_port", "http_write_timeout"}) {
+		t.Errorf("kept = %v", kept)
+	}
+	if b.ServicePort != "8080" || b.HTTPWriteTimeout != 15*time.Second || b.LogLevel != "debug" {
+		t.Errorf("ServicePort = %s, HTTPWriteTimeout = %v, LogLevel = %s", b.ServicePort, b.HTTPWriteTimeout, b.LogLevel)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Search kinds, also used to keep cursors from being replayed on the other
+// endpoint
+const (
+	searchUsers  = "users"
+	searchGroups = "groups"
+)
+
+// Match modes for the match query parameter
+const (
+	matchSubstring = "substring"
+	matchPrefix    = "prefix"
+)
+
+// searchPage runs one page of an RFC 2696 paged search, continuing from
+// cookie, and returns the cookie for the next page (empty when done).
+func (c *LDAPClient) searchPage(ctx context.Context, req *ldap.SearchRequest, size uint32, cookie []byte) ([]*ldap.Entry, []byte, error) {
+	paging := ldap.NewControlPaging(size)
+	paging.SetCookie(cookie)
+	paged := *req
+	paged.Controls = []ldap.Control{paging}
+
+	spanCtx, end := traceLDAP(ctx, opSearch, attribute.String("ldap.base_dn", req.BaseDN))
+	res, err := c.search(spanCtx, &paged)
+	end(err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, NewLDAPErrorWithCause(ErrSearchTimeout, "directory search timeout", err)
+		}
+		log.Error().Err(err).Str("filter", req.Filter).Msg("directory search failed")
+		return nil, nil, NewLDAPErrorWithCause(ErrSearchFailed, "directory search failed", err)
+	}
+	var next []byte
+	if ctrl, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		next = ctrl.Cookie
+	}
+	return res.Entries, next, nil
+}
+
+// searchFilter builds a filter matching objects of objectFilter whose attrs
+// contain (or start with) q. q is escaped, so callers cannot inject filter
+// syntax.
+func searchFilter(objectFilter string, attrs []string, q, match string) string {
+	value := ldap.EscapeFilter(q) + "*"
+	if match != matchPrefix {
+		value = "*" + value
+	}
+	var b strings.Builder
+	b.WriteString("(&" + objectFilter + "(|")
+	for _, a := range attrs {
+		b.WriteString("(" + a + "=" + value + ")")
+	}
+	b.WriteString("))")
+	return b.String()
+}
+
+// userSearchRequest searches users below UserSearchBase. The object filter
+// is LDAP_USER_FILTER with the username replaced by a presence match, e.g.
+// "(uid=*)".
+func userSearchRequest(cfg *Config, q, match string) *ldap.SearchRequest {
+	objectFilter := strings.ReplaceAll(cfg.UserSearchFilter, "%s", "*")
+	return ldap.NewSearchRequest(
+		cfg.UserSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(cfg.RequestTimeout.Seconds()), false,
+		searchFilter(objectFilter, cfg.SearchUserAttributes, q, match),
+		cfg.LookupAttributes,
+		nil,
+	)
+}
+
+func groupSearchRequest(cfg *Config, q, match string) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(
+		cfg.GroupBase(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(cfg.RequestTimeout.Seconds()), false,
+		searchFilter(cfg.SearchGroupFilter, cfg.SearchGroupAttributes, q, match),
+		[]string{cfg.GroupNameAttr, "description"},
+		nil,
+	)
+}
+
+// GroupSummary is a group in search results.
+type GroupSummary struct {
+	DN          string `json:"dn"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+func groupSummary(cfg *Config, ent *ldap.Entry) GroupSummary {
+	name := ent.GetAttributeValue(cfg.GroupNameAttr)
+	if name == "" {
+		name = rdnValue(ent.DN)
+	}
+	return GroupSummary{DN: ent.DN, Name: name, Description: ent.GetAttributeValue("description")}
+}
+
+type SearchResponse struct {
+	Ok         bool                `json:"ok"`
+	Users      []map[string]string `json:"users,omitempty"`
+	Groups     []GroupSummary      `json:"groups,omitempty"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// GET /v1/users?q=
+func UserSearchHandler(app *App) http.HandlerFunc {
+	return directorySearchHandler(app, searchUsers, userSearchRequest, func(resp *SearchResponse, entries []*ldap.Entry) {
+		resp.Users = make([]map[string]string, 0, len(entries))
+		for _, ent := range entries {
+			attrs := map[string]string{}
+			for _, a := range app.cfg.LookupAttributes {
+				if v := ent.GetAttributeValue(a); v != "" {
+					attrs[a] = v
+				}
+			}
+			resp.Users = append(resp.Users, attrs)
+		}
+	})
+}
+
+// GET /v1/groups?q=
+func GroupSearchHandler(app *App) http.HandlerFunc {
+	return directorySearchHandler(app, searchGroups, groupSearchRequest, func(resp *SearchResponse, entries []*ldap.Entry) {
+		resp.Groups = make([]GroupSummary, 0, len(entries))
+		for _, ent := range entries {
+			resp.Groups = append(resp.Groups, groupSummary(app.cfg, ent))
+		}
+	})
+}
+
+// directorySearchHandler serves a search endpoint. The first request
+// carries q (and optionally match and limit); following pages are fetched
+// with the next_cursor of the previous response only.
+func directorySearchHandler(app *App, kind string, build func(cfg *Config, q, match string) *ldap.SearchRequest, fill func(*SearchResponse, []*ldap.Entry)) http.HandlerFunc {
+	cfg, pool := app.cfg, app.cursors.pool
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		caller := requestCaller(r)
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		var cur *searchCursor
+		if id := query.Get("cursor"); id != "" {
+			if cur = app.cursors.Take(id, kind, caller); cur == nil {
+				respondJSON(w, http.StatusBadRequest, SearchResponse{Ok: false, Error: "invalid_cursor"})
+				return
+			}
+		} else {
+			q := strings.TrimSpace(query.Get("q"))
+			if len([]rune(q)) < cfg.SearchMinQueryLength {
+				respondJSON(w, http.StatusBadRequest, SearchResponse{Ok: false, Error: "query_too_short"})
+				return
+			}
+			match := query.Get("match")
+			if match == "" {
+				match = matchSubstring
+			}
+			if match != matchSubstring && match != matchPrefix {
+				respondJSON(w, http.StatusBadRequest, SearchResponse{Ok: false, Error: "invalid_match"})
+				return
+			}
+			size, err := pageSize(cfg, query.Get("limit"))
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, SearchResponse{Ok: false, Error: "invalid_limit"})
+				return
+			}
+			client, err := pool.Get(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to get ldap connection")
+				respondJSON(w, http.StatusInternalServerError, SearchResponse{Ok: false, Error: "ldap_client_error"})
+				return
+			}
+			cur = &searchCursor{kind: kind, caller: caller, req: build(cfg, q, match), size: size, client: client}
+		}
+
+		entries, next, err := cur.client.searchPage(ctx, cur.req, cur.size, cur.cookie)
+		if err != nil {
+			pool.Put(cur.client)
+			respondJSON(w, http.StatusInternalServerError, SearchResponse{Ok: false, Error: authOutcome(err, ErrSearchFailed)})
+			return
+		}
+
+		resp := SearchResponse{Ok: true}
+		fill(&resp, entries)
+		if len(next) == 0 {
+			pool.Put(cur.client)
+		} else {
+			cur.cookie = next
+			var ok bool
+			if resp.NextCursor, ok = app.cursors.Save(cur); !ok {
+				log.Warn().Str("caller", caller).Str("kind", kind).Msg("search cursor limit reached")
+				respondJSON(w, http.StatusServiceUnavailable, SearchResponse{Ok: false, Error: "too_many_cursors"})
+				return
+			}
+		}
+		log.Debug().Str("caller", caller).Str("kind", kind).Int("results", len(entries)).Msg("directory search")
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
+
+// pageSize parses the limit parameter, defaulting to SearchPageSize and
+// capped at SearchMaxPageSize.
+func pageSize(cfg *Config, limit string) (uint32, error) {
+	if limit == "" {
+		return uint32(cfg.SearchPageSize), nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid limit %q", limit)
+	}
+	if n > cfg.SearchMaxPageSize {
+		n = cfg.SearchMaxPageSize
+	}
+	return uint32(n), nil
+}
+
+// searchCursor is an unfinished paged search. Paging cookies are only valid
+// on the connection that started the search, so the cursor keeps that
+// pooled connection checked out until the search finishes or expires.
+type searchCursor struct {
+	kind    string
+	caller  string // 只有发起搜索的调用方可以继续
+	req     *ldap.SearchRequest
+	size    uint32
+	cookie  []byte
+	client  *LDAPClient
+	expires time.Time
+}
+
+// cursorStore maps opaque cursor IDs to unfinished searches. Searches run
+// on a dedicated pool of SearchMaxCursors+1 connections, so open cursors
+// never hold connections needed by /v1/auth. Each caller holds at most
+// SearchCallerCursors cursors; a caller's new cursor evicts that caller's
+// own cursor closest to expiry, never another caller's.
+type cursorStore struct {
+	cfg  *Config
+	pool *LDAPPool
+	now  func() time.Time
+
+	mu      sync.Mutex
+	cursors map[string]*searchCursor
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// searchPoolConfig sizes the dedicated search pool: one connection per
+// cursor plus one to start new searches and list group members.
+func searchPoolConfig(cfg *Config) *Config {
+	c := *cfg
+	c.PoolMaxOpen = cfg.SearchMaxCursors + 1
+	c.PoolMaxIdle = 1
+	c.PoolMinIdle = 0
+	return &c
+}
+
+// newCursorStore creates a store running searches on pool, which it owns.
+func newCursorStore(cfg *Config, pool *LDAPPool) *cursorStore {
+	s := &cursorStore{
+		cfg:     cfg,
+		pool:    pool,
+		now:     time.Now,
+		cursors: map[string]*searchCursor{},
+		stop:    make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.janitor()
+	return s
+}
+
+// Save stores cur and returns its ID. When paging is disabled
+// (SearchMaxCursors 0) the search is abandoned and "" is returned, ending
+// the result set. It fails, abandoning the search, when all cursors are
+// held by other callers.
+func (s *cursorStore) Save(cur *searchCursor) (string, bool) {
+	if s.cfg.SearchMaxCursors <= 0 {
+		s.release(cur)
+		return "", true
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+	cur.expires = s.now().Add(s.cfg.SearchCursorTTL)
+
+	s.mu.Lock()
+	var evicted *searchCursor
+	var oldest string
+	own := 0
+	for k, c := range s.cursors {
+		if c.caller != cur.caller {
+			continue
+		}
+		own++
+		if oldest == "" || c.expires.Before(s.cursors[oldest].expires) {
+			oldest = k
+		}
+	}
+	switch {
+	case own >= s.cfg.SearchCallerCursors && oldest != "":
+		evicted = s.cursors[oldest]
+		delete(s.cursors, oldest)
+	case len(s.cursors) >= s.cfg.SearchMaxCursors:
+		s.mu.Unlock()
+		s.release(cur)
+		return "", false
+	}
+	s.cursors[id] = cur
+	s.mu.Unlock()
+
+	if evicted != nil {
+		log.Debug().Str("kind", evicted.kind).Str("caller", evicted.caller).Msg("evicting search cursor")
+		s.release(evicted)
+	}
+	return id, true
+}
+
+// Take removes and returns the cursor with the given ID, or nil if it is
+// unknown, expired, belongs to another kind of search or was opened by
+// another caller.
+func (s *cursorStore) Take(id, kind, caller string) *searchCursor {
+	s.mu.Lock()
+	cur, ok := s.cursors[id]
+	if !ok || cur.kind != kind || cur.caller != caller {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.cursors, id)
+	s.mu.Unlock()
+
+	if !cur.expires.After(s.now()) {
+		s.release(cur)
+		return nil
+	}
+	return cur
+}
+
+// release abandons the paged search (a page size of 0 with the cookie, per
+// RFC 2696) and returns the connection to the pool.
+func (s *cursorStore) release(cur *searchCursor) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RequestTimeout)
+	defer cancel()
+	if _, _, err := cur.client.searchPage(ctx, cur.req, 0, cur.cookie); err != nil {
+		log.Debug().Err(err).Msg("failed to abandon paged search")
+	}
+	s.pool.Put(cur.client)
+}
+
+func (s *cursorStore) janitor() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.expire(false)
+		}
+	}
+}
+
+// expire releases expired cursors, or all of them.
+func (s *cursorStore) expire(all bool) {
+	now := s.now()
+	var expired []*searchCursor
+	s.mu.Lock()
+	for id, c := range s.cursors {
+		if all || !c.expires.After(now) {
+			expired = append(expired, c)
+			delete(s.cursors, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range expired {
+		s.release(c)
+	}
+}
+
+// Close releases all cursors and closes the search pool.
+func (s *cursorStore) Close() {
+	close(s.stop)
+	s.wg.Wait()
+	s.expire(true)
+	s.pool.Close()
+}
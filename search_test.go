@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestSearchFilter(t *testing.T) {
+	attrs := []string{"cn", "mail"}
+	if got, want := searchFilter("(uid=*)", attrs, "jo", matchSubstring), "(&(uid=*)(|(cn=*jo*)(mail=*jo*)))"; got != want {
+		t.Errorf("substring filter = %s, want %s", got, want)
+	}
+	if got, want := searchFilter("(uid=*)", attrs, "jo", matchPrefix), "(&(uid=*)(|(cn=jo*)(mail=jo*)))"; got != want {
+		t.Errorf("prefix filter = %s, want %s", got, want)
+	}
+	// filter syntax in the query must be escaped
+	if got, want := searchFilter("(uid=*)", []string{"cn"}, "*)(uid=*", matchPrefix), `(&(uid=*)(|(cn=\2a\29\28uid=\2a*)))`; got != want {
+		t.Errorf("escaped filter = %s, want %s", got, want)
+	}
+}
+
+func TestUserSearchRequest(t *testing.T) {
+	cfg := &Config{
+		UserSearchBase:       "dc=example,dc=com",
+		UserSearchFilter:     "(&(objectClass=user)(sAMAccountName=%s))",
+		SearchUserAttributes: []string{"cn"},
+		LookupAttributes:     []string{"cn", "mail"},
+	}
+	req := userSearchRequest(cfg, "jo", matchPrefix)
+	if want := "(&(&(objectClass=user)(sAMAccountName=*))(|(cn=jo*)))"; req.Filter != want {
+		t.Errorf("filter = %s, want %s", req.Filter, want)
+	}
+	if req.BaseDN != cfg.UserSearchBase || len(req.Attributes) != 2 {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+// pagedSearch serves 5 users in pages of the requested size, using the
+// offset as cookie.
+func pagedSearch(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	paging, ok := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if !ok {
+		return &ldap.SearchResult{}, nil
+	}
+	offset, _ := strconv.Atoi(string(paging.Cookie))
+	res := &ldap.SearchResult{}
+	end := min(offset+int(paging.PagingSize), 5)
+	for i := offset; i < end; i++ {
+		res.Entries = append(res.Entries, ldap.NewEntry("uid=u"+strconv.Itoa(i)+",dc=example,dc=com", map[string][]string{"cn": {"User " + strconv.Itoa(i)}}))
+	}
+	next := ldap.NewControlPaging(paging.PagingSize)
+	if end < 5 && paging.PagingSize > 0 {
+		next.SetCookie([]byte(strconv.Itoa(end)))
+	}
+	res.Controls = []ldap.Control{next}
+	return res, nil
+}
+
+func searchTestApp(t *testing.T) *App {
+	app, d := passwordChangeTestApp(t, func(fc *fakeConn) { fc.searchFn = pagedSearch })
+	app.cfg.UserSearchBase = "dc=example,dc=com"
+	app.cfg.SearchUserAttributes = []string{"cn"}
+	app.cfg.LookupAttributes = []string{"cn"}
+	app.cfg.SearchMinQueryLength = 2
+	app.cfg.SearchPageSize = 2
+	app.cfg.SearchMaxPageSize = 10
+	app.cfg.SearchCursorTTL = time.Minute
+	app.cfg.SearchMaxCursors = 2
+	app.cfg.SearchCallerCursors = 1
+	app.cursors = newCursorStore(app.cfg, newLDAPPool(searchPoolConfig(app.cfg), d.dial(app.cfg)))
+	t.Cleanup(app.cursors.Close)
+	return app
+}
+
+func getSearch(h http.HandlerFunc, query string) (int, SearchResponse) {
+	return getSearchAs(h, "", query)
+}
+
+// getSearchAs runs a search as the given authenticated caller.
+func getSearchAs(h http.HandlerFunc, caller, query string) (int, SearchResponse) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/users?"+query, nil)
+	w := httptest.NewRecorder()
+	h(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	var resp SearchResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestUserSearchPaging(t *testing.T) {
+	app := searchTestApp(t)
+	h := UserSearchHandler(app)
+
+	var names []string
+	status, resp := getSearch(h, "q=user")
+	for page := 0; ; page++ {
+		if status != http.StatusOK {
+			t.Fatalf("page %d: status %d, %+v", page, status, resp)
+		}
+		for _, u := range resp.Users {
+			names = append(names, u["cn"])
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		if n := app.cursors.pool.Stats().InUse; n != 1 {
+			t.Errorf("page %d: cursor should pin one search connection, in use %d", page, n)
+		}
+		if n := app.pool.Stats().InUse; n != 0 {
+			t.Errorf("page %d: cursor holds a connection of the main pool, in use %d", page, n)
+		}
+		status, resp = getSearch(h, "cursor="+resp.NextCursor)
+	}
+	if len(names) != 5 || names[4] != "User 4" {
+		t.Errorf("got %v, want 5 users", names)
+	}
+	if n := app.cursors.pool.Stats().InUse; n != 0 {
+		t.Errorf("connection not returned after the last page, in use %d", n)
+	}
+}
+
+func TestUserSearchValidation(t *testing.T) {
+	app := searchTestApp(t)
+	h := UserSearchHandler(app)
+
+	for query, want := range map[string]string{
+		"q=a":              "query_too_short",
+		"q=jo&match=regex": "invalid_match",
+		"q=jo&limit=-1":    "invalid_limit",
+		"cursor=bogus":     "invalid_cursor",
+	} {
+		if status, resp := getSearch(h, query); status != http.StatusBadRequest || resp.Error != want {
+			t.Errorf("%s: status %d, error %q, want %q", query, status, resp.Error, want)
+		}
+	}
+
+	// a users cursor is not accepted by the groups endpoint
+	_, resp := getSearch(h, "q=user")
+	if status, resp := getSearch(GroupSearchHandler(app), "cursor="+resp.NextCursor); status != http.StatusBadRequest || resp.Error != "invalid_cursor" {
+		t.Errorf("cross-endpoint cursor: status %d, %+v", status, resp)
+	}
+}
+
+func TestCursorStoreEvictionAndExpiry(t *testing.T) {
+	app := searchTestApp(t)
+	now := time.Now()
+	app.cursors.now = func() time.Time { return now }
+	h := UserSearchHandler(app)
+
+	_, first := getSearch(h, "q=user")
+	_, second := getSearch(h, "q=user")
+	// SearchCallerCursors is 1, so the first cursor was evicted
+	if status, _ := getSearch(h, "cursor="+first.NextCursor); status != http.StatusBadRequest {
+		t.Errorf("evicted cursor: status %d", status)
+	}
+	if n := app.cursors.pool.Stats().InUse; n != 1 {
+		t.Errorf("in use %d, want 1 for the remaining cursor", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if status, _ := getSearch(h, "cursor="+second.NextCursor); status != http.StatusBadRequest {
+		t.Errorf("expired cursor: status %d", status)
+	}
+	if n := app.cursors.pool.Stats().InUse; n != 0 {
+		t.Errorf("expired cursor kept its connection, in use %d", n)
+	}
+}
+
+func TestCursorStorePerCaller(t *testing.T) {
+	app := searchTestApp(t)
+	h := UserSearchHandler(app)
+
+	_, a := getSearchAs(h, "key:a", "q=user")
+	_, b := getSearchAs(h, "key:b", "q=user")
+	if a.NextCursor == "" || b.NextCursor == "" {
+		t.Fatalf("expected cursors for both callers, got %q and %q", a.NextCursor, b.NextCursor)
+	}
+	// 游标已用完全部配额，第三个调用方不能驱逐其他调用方的游标
+	if status, resp := getSearchAs(h, "key:c", "q=user"); status != http.StatusServiceUnavailable || resp.Error != "too_many_cursors" {
+		t.Errorf("third caller: status %d, %+v", status, resp)
+	}
+	if status, _ := getSearchAs(h, "key:b", "cursor="+a.NextCursor); status != http.StatusBadRequest {
+		t.Errorf("cursor of another caller: status %d", status)
+	}
+	if status, resp := getSearchAs(h, "key:a", "cursor="+a.NextCursor); status != http.StatusOK || len(resp.Users) != 2 {
+		t.Errorf("own cursor: status %d, %+v", status, resp)
+	}
+}
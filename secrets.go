@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SecretProvider resolves secret references of the form
+// "<scheme>://<path>#<key>", used in place of a secret setting's value.
+type SecretProvider interface {
+	// Secret returns the value stored under key in the secret at path.
+	Secret(ctx context.Context, path, key string) (string, error)
+}
+
+// secretProviders maps a reference scheme to the constructor of its
+// provider. Constructors fail when the provider is not configured.
+var secretProviders = map[string]func(cfg *Config) (SecretProvider, error){
+	"vault": newVaultProvider,
+}
+
+// secretSetting is a setting that may be read from a file (its *_FILE
+// variant) or, with refs, from a secret provider.
+type secretSetting struct {
+	key  string    // 环境变量名
+	file string    // key_FILE 的值
+	refs bool      // 是否解析 <scheme>:// 引用
+	str  *string   // 字符串设置，或
+	list *[]string // 列表设置
+}
+
+func (c *Config) secretSettings() []secretSetting {
+	// VAULT_TOKEN 排在最前，解析引用前需要先从文件读取
+	return []secretSetting{
+		{key: "VAULT_TOKEN", file: c.VaultTokenFile, str: &c.VaultToken},
+		{key: "LDAP_BIND_PASSWORD", file: c.BindPasswordFile, refs: true, str: &c.BindPassword},
+		{key: "LDAP_BIND_NT_HASH", file: c.BindNTHashFile, refs: true, str: &c.BindNTHash},
+		{key: "ADMIN_API_KEYS", file: c.AdminAPIKeysFile, refs: true, list: &c.AdminAPIKeys},
+		{key: "LOOKUP_API_KEYS", file: c.LookupAPIKeysFile, refs: true, list: &c.LookupAPIKeys},
+	}
+}
+
+// secretFiles returns the *_FILE paths in use, which are watched for
+// rotation.
+func (c *Config) secretFiles() []string {
+	var files []string
+	for _, s := range c.secretSettings() {
+		if s.file != "" {
+			files = append(files, s.file)
+		}
+	}
+	return files
+}
+
+func (s secretSetting) isSet() bool {
+	if s.str != nil {
+		return *s.str != ""
+	}
+	return len(*s.list) > 0
+}
+
+// set stores the content of a secret file or provider value. Lists are
+// separated by commas or newlines; a trailing newline is not part of a
+// password.
+func (s secretSetting) set(v string) {
+	if s.str != nil {
+		*s.str = strings.TrimRight(v, "\r\n")
+		return
+	}
+	*s.list = splitList(strings.NewReplacer("\r\n", ",", "\n", ",").Replace(v))
+}
+
+// resolveSecrets reads the *_FILE settings and resolves secret provider
+// references in place. Problems are reported like Config.Validate, without
+// secret values.
+func resolveSecrets(c *Config) error {
+	var problems ConfigErrors
+	add := func(key, format string, args ...any) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	for _, s := range c.secretSettings() {
+		if s.file == "" {
+			continue
+		}
+		if s.isSet() {
+			add(s.key+"_FILE", "cannot be combined with %s, set only one", s.key)
+			continue
+		}
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			add(s.key+"_FILE", "cannot read %s: %v", s.file, errors.Unwrap(err))
+			continue
+		}
+		s.set(string(data))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	providers := map[string]SecretProvider{}
+	resolve := func(key, v string) (string, bool) {
+		scheme, rest, ok := strings.Cut(v, "://")
+		if !ok || secretProviders[scheme] == nil {
+			return v, true
+		}
+		path, name, _ := strings.Cut(rest, "#")
+		if path == "" || name == "" {
+			add(key, "secret reference %q must have the form %s://<path>#<key>", v, scheme)
+			return "", false
+		}
+		p := providers[scheme]
+		if p == nil {
+			var err error
+			if p, err = secretProviders[scheme](c); err != nil {
+				add(key, "secret reference %q: %v", v, err)
+				return "", false
+			}
+			providers[scheme] = p
+		}
+		secret, err := p.Secret(ctx, path, name)
+		if err != nil {
+			add(key, "secret reference %q: %v", v, err)
+			return "", false
+		}
+		return secret, true
+	}
+
+	for _, s := range c.secretSettings() {
+		if !s.refs {
+			continue
+		}
+		if s.str != nil {
+			if v, ok := resolve(s.key, *s.str); ok {
+				*s.str = v
+			}
+			continue
+		}
+		var out []string
+		for _, item := range *s.list {
+			if v, ok := resolve(s.key, item); ok {
+				out = append(out, splitList(v)...)
+			}
+		}
+		*s.list = out
+	}
+	return problems.err()
+}
+
+// secretProvidersConfigured reports whether any secret provider can be
+// constructed from cfg, i.e. whether secrets may need refreshing.
+func secretProvidersConfigured(cfg *Config) bool {
+	for _, newProvider := range secretProviders {
+		if _, err := newProvider(cfg); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultProvider reads secrets from a HashiCorp Vault KV engine. References
+// are vault://<mount>/<path>#<key>; for KV version 1 the whole reference
+// path is the API path.
+type vaultProvider struct {
+	addr      string
+	token     string
+	namespace string
+	kvVersion int
+	client    *http.Client
+}
+
+const vaultMaxResponseSize = 1 << 20
+
+func newVaultProvider(cfg *Config) (SecretProvider, error) {
+	if cfg.VaultAddr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is required for vault:// secrets")
+	}
+	if cfg.VaultToken == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN or VAULT_TOKEN_FILE is required for vault:// secrets")
+	}
+	if cfg.VaultKVVersion != 1 && cfg.VaultKVVersion != 2 {
+		return nil, fmt.Errorf("VAULT_KV_VERSION must be 1 or 2")
+	}
+	return &vaultProvider{
+		addr:      strings.TrimRight(cfg.VaultAddr, "/"),
+		token:     cfg.VaultToken,
+		namespace: cfg.VaultNamespace,
+		kvVersion: cfg.VaultKVVersion,
+		client:    &http.Client{Timeout: cfg.RequestTimeout},
+	}, nil
+}
+
+func (v *vaultProvider) Secret(ctx context.Context, path, key string) (string, error) {
+	path = strings.Trim(path, "/")
+	apiPath := path
+	if v.kvVersion == 2 {
+		// KV v2 的 API 路径在挂载点之后插入 data/
+		mount, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			return "", fmt.Errorf("path %q has no mount, expected <mount>/<path>", path)
+		}
+		apiPath = mount + "/data/" + rest
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+apiPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, vaultMaxResponseSize)).Decode(&body)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("secret %s not found in vault", path)
+	case resp.StatusCode != http.StatusOK && len(body.Errors) > 0:
+		return "", fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	case decodeErr != nil:
+		return "", fmt.Errorf("decode vault response: %w", decodeErr)
+	}
+
+	data := body.Data
+	if v.kvVersion == 2 {
+		var inner struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &inner); err != nil {
+			return "", fmt.Errorf("decode vault response: %w", err)
+		}
+		data = inner.Data
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("decode vault response: %w", err)
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", path, key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q of secret %s is not a string", key, path)
+	}
+	return s, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newVaultServer stands in for Vault, serving KV v2 secrets under the
+// "secret" mount and KV v1 secrets under "kv".
+func newVaultServer(t *testing.T, secrets map[string]map[string]any) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		data, ok := secrets[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{}})
+			return
+		}
+		var body any = map[string]any{"data": data}
+		if strings.HasPrefix(path, "secret/data/") {
+			body = map[string]any{"data": map[string]any{"data": data, "metadata": map[string]any{"version": 3}}}
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSecretFiles(t *testing.T) {
+	t.Setenv("LDAP_BIND_DN", "cn=svc,dc=example,dc=com")
+	t.Setenv("LDAP_BIND_PASSWORD_FILE", writeConfigFile(t, "password", "s3cret \n"))
+	t.Setenv("ADMIN_API_KEYS_FILE", writeConfigFile(t, "admin-keys", "key-1\nkey-2,key-3\n\n"))
+
+	cfg, err := loadValidConfig("")
+	if err != nil {
+		t.Fatalf("loadValidConfig: %v", err)
+	}
+	if cfg.BindPassword != "s3cret " {
+		t.Errorf("BindPassword = %q, only the trailing newline should be removed", cfg.BindPassword)
+	}
+	if !slices.Equal(cfg.AdminAPIKeys, []string{"key-1", "key-2", "key-3"}) {
+		t.Errorf("AdminAPIKeys = %q", cfg.AdminAPIKeys)
+	}
+}
+
+func TestSecretFileErrors(t *testing.T) {
+	t.Setenv("LDAP_BIND_PASSWORD", "plain")
+	t.Setenv("LDAP_BIND_PASSWORD_FILE", writeConfigFile(t, "password", "s3cret"))
+	t.Setenv("LOOKUP_API_KEYS_FILE", "/nonexistent/lookup-keys")
+
+	_, err := LoadConfig("")
+	var problems ConfigErrors
+	if GetLDAPErrorCode(err) != ErrInvalidConfig || !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected 2 configuration problems, got %v", err)
+	}
+	if !strings.HasPrefix(problems[0], "LDAP_BIND_PASSWORD_FILE: cannot be combined with LDAP_BIND_PASSWORD") ||
+		!strings.HasPrefix(problems[1], "LOOKUP_API_KEYS_FILE: cannot read /nonexistent/lookup-keys") {
+		t.Errorf("problems = %q", problems)
+	}
+	if strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "plain") {
+		t.Error("secret value leaked into error")
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	srv := newVaultServer(t, map[string]map[string]any{
+		"secret/data/ldap/service": {"password": "from-vault", "port": 389},
+		"kv/lookup":                {"keys": "crm-key, hr-key"},
+	})
+	t.Setenv("VAULT_ADDR", srv.URL+"/")
+	t.Setenv("VAULT_TOKEN_FILE", writeConfigFile(t, "token", "root-token\n"))
+	t.Setenv("LDAP_BIND_PASSWORD", "vault://secret/ldap/service#password")
+	t.Setenv("LOOKUP_API_KEYS", "static-key,vault://kv/lookup#keys")
+
+	cfg, err := LoadConfig("")
+	if err == nil || !strings.Contains(err.Error(), `secret kv/lookup not found in vault`) {
+		t.Fatalf("KV v2 lookup of a v1 path should fail, got %v", err)
+	}
+
+	t.Setenv("LOOKUP_API_KEYS", "static-key")
+	if cfg, err = LoadConfig(""); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.BindPassword != "from-vault" {
+		t.Errorf("BindPassword = %q", cfg.BindPassword)
+	}
+
+	t.Setenv("VAULT_KV_VERSION", "1")
+	t.Setenv("LDAP_BIND_PASSWORD", "")
+	t.Setenv("LOOKUP_API_KEYS", "static-key,vault://kv/lookup#keys")
+	if cfg, err = LoadConfig(""); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !slices.Equal(cfg.LookupAPIKeys, []string{"static-key", "crm-key", "hr-key"}) {
+		t.Errorf("LookupAPIKeys = %q", cfg.LookupAPIKeys)
+	}
+}
+
+func TestVaultProviderErrors(t *testing.T) {
+	srv := newVaultServer(t, map[string]map[string]any{
+		"secret/data/ldap/service": {"password": "from-vault", "port": 389},
+	})
+	cfg := defaultConfig()
+	cfg.VaultAddr = srv.URL
+	cfg.VaultToken = "root-token"
+	p, err := newVaultProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, want := range map[string]string{
+		"secret/ldap/service#port":    `key "port" of secret secret/ldap/service is not a string`,
+		"secret/ldap/service#missing": `secret secret/ldap/service has no key "missing"`,
+		"secret/ldap/other#password":  "secret secret/ldap/other not found in vault",
+		"ldap#password":               "has no mount",
+	} {
+		path, key, _ := strings.Cut(ref, "#")
+		if _, err := p.Secret(t.Context(), path, key); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", ref, err, want)
+		}
+	}
+
+	cfg.VaultToken = "wrong"
+	p, _ = newVaultProvider(cfg)
+	if _, err := p.Secret(t.Context(), "secret/ldap/service", "password"); err == nil || !strings.Contains(err.Error(), "403 Forbidden: permission denied") {
+		t.Errorf("err = %v", err)
+	}
+
+	cfg.VaultAddr = ""
+	if _, err := newVaultProvider(cfg); err == nil || secretProvidersConfigured(cfg) {
+		t.Error("vault provider should require VAULT_ADDR")
+	}
+}
+
+func TestReloaderWatchesSecretFiles(t *testing.T) {
+	password := writeConfigFile(t, "password", "old\n")
+	r, _ := newTestReloader(t, reloadTestConfig+"ldap_bind_dn: cn=svc\nldap_bind_password_file: "+password+"\n")
+	if r.Config().BindPassword != "old" {
+		t.Fatalf("BindPassword = %q", r.Config().BindPassword)
+	}
+	r.Watch(10 * time.Millisecond)
+
+	rewriteConfigFile(t, password, "new\n")
+	waitFor(t, "secret file reload", func() bool { return r.Config().BindPassword == "new" })
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Server selection strategies for LDAP_SERVER_STRATEGY
+const (
+	StrategyFailover     = "failover"      // always prefer servers in configured order
+	StrategyRoundRobin   = "round_robin"   // rotate the starting server on every dial
+	StrategyLeastLatency = "least_latency" // prefer the server with the lowest dial latency
+)
+
+// latencyWeight is the smoothing factor of the dial latency moving average.
+const latencyWeight = 0.3
+
+type ldapServer struct {
+	url          string
+	fails        int           // consecutive connection failures
+	ejectedUntil time.Time     // zero when the server is in rotation
+	latency      time.Duration // moving average of successful dial latency
+}
+
+// ServerSet tracks the health of the configured LDAP servers and decides in
+// which order they are tried.
+type ServerSet struct {
+	cfg *Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	servers []*ldapServer
+	next    int // round robin cursor
+}
+
+func NewServerSet(cfg *Config) *ServerSet {
+	s := &ServerSet{cfg: cfg, now: time.Now}
+	s.SetURLs(cfg.ServerURLs())
+	return s
+}
+
+// SetURLs replaces the server list, keeping the health state of servers that
+// remain in it.
+func (s *ServerSet) SetURLs(urls []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := make(map[string]*ldapServer, len(s.servers))
+	for _, srv := range s.servers {
+		known[srv.url] = srv
+	}
+	servers := make([]*ldapServer, 0, len(urls))
+	for _, u := range urls {
+		if srv, ok := known[u]; ok {
+			servers = append(servers, srv)
+		} else {
+			servers = append(servers, &ldapServer{url: u})
+		}
+	}
+	s.servers = servers
+}
+
+// URLs returns the current server list in configured order.
+func (s *ServerSet) URLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls := make([]string, len(s.servers))
+	for i, srv := range s.servers {
+		urls[i] = srv.url
+	}
+	return urls
+}
+
+// candidates returns the server URLs to try, in order. Ejected servers are
+// skipped unless every server is ejected, in which case all are tried
+// rather than failing without a single attempt.
+func (s *ServerSet) candidates() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var healthy, ejected []*ldapServer
+	for _, srv := range s.servers {
+		if now.Before(srv.ejectedUntil) {
+			ejected = append(ejected, srv)
+		} else {
+			healthy = append(healthy, srv)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = ejected
+	}
+
+	switch s.cfg.ServerStrategy {
+	case StrategyRoundRobin:
+		if n := len(healthy); n > 0 {
+			start := s.next % n
+			s.next++
+			rotated := make([]*ldapServer, 0, n)
+			rotated = append(rotated, healthy[start:]...)
+			healthy = append(rotated, healthy[:start]...)
+		}
+	case StrategyLeastLatency:
+		// servers without a measurement sort first so they get probed
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	}
+
+	urls := make([]string, len(healthy))
+	for i, srv := range healthy {
+		urls[i] = srv.url
+	}
+	return urls
+}
+
+func (s *ServerSet) find(url string) *ldapServer {
+	for _, srv := range s.servers {
+		if srv.url == url {
+			return srv
+		}
+	}
+	return nil
+}
+
+func (s *ServerSet) markSuccess(url string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	srv := s.find(url)
+	if srv == nil {
+		return
+	}
+	srv.fails = 0
+	srv.ejectedUntil = time.Time{}
+	if srv.latency == 0 {
+		srv.latency = latency
+	} else {
+		srv.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(srv.latency))
+	}
+}
+
+func (s *ServerSet) markFailure(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	srv := s.find(url)
+	if srv == nil {
+		return
+	}
+	srv.fails++
+	serverDialFailures.WithLabelValues(url).Inc()
+	if s.cfg.ServerMaxFails > 0 && srv.fails >= s.cfg.ServerMaxFails {
+		srv.ejectedUntil = s.now().Add(s.cfg.ServerEjectDuration)
+		log.Warn().Str("address", url).Int("fails", srv.fails).Dur("for", s.cfg.ServerEjectDuration).Msg("ejecting LDAP server after consecutive failures")
+	}
+}
+
+// Dial connects to the first reachable server. Connection failures and
+// timeouts move on to the next candidate; other errors (such as a rejected
+// service bind) are returned immediately.
+func (s *ServerSet) Dial(ctx context.Context) (*LDAPClient, error) {
+	return s.dialWith(ctx, func(ctx context.Context, url string) (*LDAPClient, error) {
+		return newLDAPClient(ctx, s.cfg, url)
+	})
+}
+
+func (s *ServerSet) dialWith(ctx context.Context, dial func(ctx context.Context, url string) (*LDAPClient, error)) (*LDAPClient, error) {
+	urls := s.candidates()
+	if len(urls) == 0 {
+		return nil, NewLDAPError(ErrInvalidConfig, "no LDAP servers configured")
+	}
+
+	var lastErr error
+	for _, url := range urls {
+		start := s.now()
+		c, err := dial(ctx, url)
+		if err == nil {
+			s.markSuccess(url, s.now().Sub(start))
+			return c, nil
+		}
+		lastErr = err
+		code := GetLDAPErrorCode(err)
+		if code != ErrConnectionFailed && code != ErrConnectionTimeout {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			// 调用方取消或超时，不代表服务器有问题
+			break
+		}
+		s.markFailure(url)
+		log.Warn().Err(err).Str("address", url).Msg("LDAP server unavailable, trying next server")
+	}
+	return nil, lastErr
+}
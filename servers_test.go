@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testServerConfig(strategy string) *Config {
+	return &Config{
+		LDAPURLs:            []string{"ldap://a", "ldap://b", "ldap://c"},
+		ServerStrategy:      strategy,
+		ServerMaxFails:      2,
+		ServerEjectDuration: time.Minute,
+	}
+}
+
+func TestServerURLs(t *testing.T) {
+	cfg := &Config{LDAPURL: "ldap://a:389, ldap://b:389"}
+	if got, want := cfg.ServerURLs(), []string{"ldap://a:389", "ldap://b:389"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	cfg.LDAPURLs = []string{"ldap://c:389"}
+	if got, want := cfg.ServerURLs(), []string{"ldap://c:389"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected LDAPURLs to take precedence, got %v", got)
+	}
+}
+
+func TestServerSetFailoverOrder(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	for i := 0; i < 2; i++ {
+		if got := s.candidates(); !reflect.DeepEqual(got, []string{"ldap://a", "ldap://b", "ldap://c"}) {
+			t.Errorf("unexpected failover order %v", got)
+		}
+	}
+}
+
+func TestServerSetRoundRobin(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyRoundRobin))
+	var firsts []string
+	for i := 0; i < 4; i++ {
+		firsts = append(firsts, s.candidates()[0])
+	}
+	if want := []string{"ldap://a", "ldap://b", "ldap://c", "ldap://a"}; !reflect.DeepEqual(firsts, want) {
+		t.Errorf("expected rotation %v, got %v", want, firsts)
+	}
+}
+
+func TestServerSetLeastLatency(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyLeastLatency))
+	s.markSuccess("ldap://a", 30*time.Millisecond)
+	s.markSuccess("ldap://b", 10*time.Millisecond)
+	s.markSuccess("ldap://c", 20*time.Millisecond)
+	if got, want := s.candidates(), []string{"ldap://b", "ldap://c", "ldap://a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestServerSetEjectsAfterConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	s.now = func() time.Time { return now }
+
+	s.markFailure("ldap://a")
+	if got := s.candidates(); got[0] != "ldap://a" {
+		t.Errorf("server should stay in rotation after one failure, got %v", got)
+	}
+	s.markFailure("ldap://a")
+	if got, want := s.candidates(), []string{"ldap://b", "ldap://c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ejected server to be skipped, got %v", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := s.candidates(); got[0] != "ldap://a" {
+		t.Errorf("expected server back in rotation after eject duration, got %v", got)
+	}
+}
+
+func TestServerSetDialRetriesNextServer(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	var tried []string
+	c, err := s.dialWith(context.Background(), func(ctx context.Context, url string) (*LDAPClient, error) {
+		tried = append(tried, url)
+		if url == "ldap://a" {
+			return nil, NewLDAPError(ErrConnectionFailed, "refused")
+		}
+		return &LDAPClient{address: url}, nil
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if c.address != "ldap://b" {
+		t.Errorf("expected connection to ldap://b, got %s", c.address)
+	}
+	if want := []string{"ldap://a", "ldap://b"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("expected attempts %v, got %v", want, tried)
+	}
+}
+
+func TestServerSetDialStopsOnBindFailure(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	attempts := 0
+	_, err := s.dialWith(context.Background(), func(ctx context.Context, url string) (*LDAPClient, error) {
+		attempts++
+		return nil, NewLDAPError(ErrBindFailed, "invalid service credentials")
+	})
+	if GetLDAPErrorCode(err) != ErrBindFailed {
+		t.Errorf("expected ErrBindFailed, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected bind failure not to fail over, got %d attempts", attempts)
+	}
+}
+
+func TestServerSetDialIgnoresCallerCancel(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		_, err := s.dialWith(ctx, func(ctx context.Context, url string) (*LDAPClient, error) {
+			cancel()
+			return nil, NewLDAPErrorWithCause(ErrConnectionTimeout, "connection timeout", ctx.Err())
+		})
+		if GetLDAPErrorCode(err) != ErrConnectionTimeout {
+			t.Fatalf("expected ErrConnectionTimeout, got %v", err)
+		}
+	}
+	if got := s.candidates(); got[0] != "ldap://a" {
+		t.Errorf("caller cancellation should not eject a server, got %v", got)
+	}
+}
+
+func TestServerSetKeepsHealthOnUpdate(t *testing.T) {
+	s := NewServerSet(testServerConfig(StrategyFailover))
+	s.markFailure("ldap://b")
+	s.markFailure("ldap://b")
+	s.SetURLs([]string{"ldap://b", "ldap://d"})
+	if got, want := s.candidates(), []string{"ldap://d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected ldap://b to remain ejected, got %v", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// serverTLSConfig builds the TLS configuration of the HTTP listener, or
+// returns nil when SERVICE_TLS_CERT is not set and the service listens on
+// plain HTTP. With SERVICE_TLS_CLIENT_CA, client certificates are verified
+// when presented; they are optional so that endpoints not using them keep
+// working without one.
+func serverTLSConfig(cfg *Config) (*tls.Config, error) {
+	if cfg.ServiceTLSCert == "" {
+		if cfg.ServiceTLSClientCA != "" {
+			return nil, NewLDAPError(ErrInvalidConfig, "SERVICE_TLS_CLIENT_CA requires SERVICE_TLS_CERT")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.ServiceTLSCert, cfg.ServiceTLSKey)
+	if err != nil {
+		return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "failed to load service TLS certificate", err)
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ServiceTLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.ServiceTLSClientCA)
+		if err != nil {
+			return nil, NewLDAPErrorWithCause(ErrInvalidConfig, "failed to read client CA", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, NewLDAPError(ErrInvalidConfig, fmt.Sprintf("no certificates found in %s", cfg.ServiceTLSClientCA))
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// srvResolver is the subset of *net.Resolver used for discovery, so tests can
+// substitute a local stand-in.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SRVDiscovery periodically resolves _<service>._tcp.<domain> and feeds the
+// resulting servers into a ServerSet.
+type SRVDiscovery struct {
+	cfg      *Config
+	servers  *ServerSet
+	resolver srvResolver
+	// intn returns a random number in [0, n) for weighted selection
+	intn func(n int) int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewSRVDiscovery(cfg *Config, servers *ServerSet) *SRVDiscovery {
+	return &SRVDiscovery{
+		cfg:      cfg,
+		servers:  servers,
+		resolver: net.DefaultResolver,
+		intn:     rand.Intn,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start performs an initial lookup and then refreshes every LDAPSRVRefresh.
+// If the initial lookup fails the statically configured servers stay in use.
+func (d *SRVDiscovery) Start() {
+	if err := d.Refresh(context.Background()); err != nil {
+		log.Error().Err(err).Str("domain", d.cfg.LDAPSRVDomain).Msg("SRV discovery failed, using configured LDAP servers")
+	}
+	if d.cfg.LDAPSRVRefresh <= 0 {
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.cfg.LDAPSRVRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				if err := d.Refresh(context.Background()); err != nil {
+					log.Warn().Err(err).Str("domain", d.cfg.LDAPSRVDomain).Msg("SRV refresh failed, keeping previous servers")
+				}
+			}
+		}
+	}()
+}
+
+func (d *SRVDiscovery) Close() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Refresh resolves the SRV records once and replaces the server list. On
+// error the current list is left untouched.
+func (d *SRVDiscovery) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.ConnTimeout)
+	defer cancel()
+
+	_, records, err := d.resolver.LookupSRV(ctx, d.cfg.LDAPSRVService, "tcp", d.cfg.LDAPSRVDomain)
+	if err != nil {
+		return NewLDAPErrorWithCause(ErrConnectionFailed, "SRV lookup failed", err)
+	}
+	if len(records) == 0 {
+		return NewLDAPError(ErrConnectionFailed, "SRV lookup returned no records")
+	}
+
+	ordered := orderSRV(records, d.intn)
+	urls := make([]string, 0, len(ordered))
+	for _, rec := range ordered {
+		urls = append(urls, srvURL(d.cfg, rec))
+	}
+	d.servers.SetURLs(urls)
+	log.Debug().Strs("servers", urls).Msg("SRV discovery updated LDAP servers")
+	return nil
+}
+
+// srvLDAPSPorts maps the plain LDAP and global catalog ports published in
+// _ldap._tcp / _gc._tcp records to their LDAPS counterparts.
+var srvLDAPSPorts = map[uint16]uint16{389: 636, 3268: 3269}
+
+// srvURL builds an LDAP URL for a SRV target, using ldaps:// when LDAPS is
+// enabled. AD only publishes the plain ports, so these are mapped to the
+// LDAPS ports; other ports (e.g. from _ldaps._tcp records) are kept.
+func srvURL(cfg *Config, rec *net.SRV) string {
+	scheme, port := "ldap", rec.Port
+	if cfg.UseLDAPS {
+		scheme = "ldaps"
+		if p, ok := srvLDAPSPorts[port]; ok {
+			port = p
+		}
+	}
+	host := strings.TrimSuffix(rec.Target, ".")
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, fmt.Sprint(port)))
+}
+
+// orderSRV orders records as described in RFC 2782: ascending priority, and
+// within a priority a weighted random order.
+func orderSRV(records []*net.SRV, intn func(n int) int) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	out := make([]*net.SRV, 0, len(sorted))
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+			j++
+		}
+		out = append(out, weightedOrder(sorted[i:j], intn)...)
+		i = j
+	}
+	return out
+}
+
+func weightedOrder(group []*net.SRV, intn func(n int) int) []*net.SRV {
+	// zero-weight records go first so they have a small chance of selection
+	remaining := make([]*net.SRV, 0, len(group))
+	for _, rec := range group {
+		if rec.Weight == 0 {
+			remaining = append(remaining, rec)
+		}
+	}
+	for _, rec := range group {
+		if rec.Weight != 0 {
+			remaining = append(remaining, rec)
+		}
+	}
+
+	out := make([]*net.SRV, 0, len(group))
+	for len(remaining) > 0 {
+		total := 0
+		for _, rec := range remaining {
+			total += int(rec.Weight)
+		}
+		pick := 0
+		if total > 0 {
+			r := intn(total + 1)
+			sum := 0
+			for i, rec := range remaining {
+				sum += int(rec.Weight)
+				if sum >= r {
+					pick = i
+					break
+				}
+			}
+		}
+		out = append(out, remaining[pick])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+	return out
+}
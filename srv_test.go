@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubResolver stands in for DNS, answering SRV queries from a fixed table.
+type stubResolver struct {
+	records map[string][]*net.SRV
+	err     error
+	queries []string
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	q := "_" + service + "._" + proto + "." + name
+	r.queries = append(r.queries, q)
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return q, r.records[q], nil
+}
+
+func testSRVConfig() *Config {
+	return &Config{
+		LDAPSRVDomain:  "example.com",
+		LDAPSRVService: "ldap",
+		ConnTimeout:    time.Second,
+		ServerStrategy: StrategyFailover,
+	}
+}
+
+func TestOrderSRVPriority(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "backup.example.com.", Port: 389, Priority: 10, Weight: 100},
+		{Target: "dc1.example.com.", Port: 389, Priority: 0, Weight: 100},
+	}
+	got := orderSRV(records, func(n int) int { return 0 })
+	if got[0].Target != "dc1.example.com." || got[1].Target != "backup.example.com." {
+		t.Errorf("expected lower priority value first, got %s, %s", got[0].Target, got[1].Target)
+	}
+}
+
+func TestOrderSRVWeight(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "light", Priority: 0, Weight: 10},
+		{Target: "heavy", Priority: 0, Weight: 90},
+	}
+	// a roll past the first record's weight selects the heavier one
+	got := orderSRV(records, func(n int) int { return n - 1 })
+	if got[0].Target != "heavy" {
+		t.Errorf("expected heavy first for high roll, got %s", got[0].Target)
+	}
+	got = orderSRV(records, func(n int) int { return 5 })
+	if got[0].Target != "light" {
+		t.Errorf("expected light first for low roll, got %s", got[0].Target)
+	}
+}
+
+func TestSRVDiscoveryRefresh(t *testing.T) {
+	cfg := testSRVConfig()
+	cfg.LDAPURL = "ldap://static:389"
+	servers := NewServerSet(cfg)
+	r := &stubResolver{records: map[string][]*net.SRV{
+		"_ldap._tcp.example.com": {
+			{Target: "dc2.example.com.", Port: 389, Priority: 1, Weight: 0},
+			{Target: "dc1.example.com.", Port: 389, Priority: 0, Weight: 0},
+		},
+	}}
+	d := NewSRVDiscovery(cfg, servers)
+	d.resolver = r
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	want := []string{"ldap://dc1.example.com:389", "ldap://dc2.example.com:389"}
+	if got := servers.URLs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSRVDiscoveryUsesLDAPS(t *testing.T) {
+	cfg := testSRVConfig()
+	cfg.UseLDAPS = true
+	for port, want := range map[uint16]string{
+		389:  "ldaps://dc1.example.com:636", // _ldap._tcp as published by AD
+		3268: "ldaps://dc1.example.com:3269",
+		1636: "ldaps://dc1.example.com:1636",
+	} {
+		if got := srvURL(cfg, &net.SRV{Target: "dc1.example.com.", Port: port}); got != want {
+			t.Errorf("port %d: got %s, want %s", port, got, want)
+		}
+	}
+}
+
+func TestSRVDiscoveryKeepsServersOnFailure(t *testing.T) {
+	cfg := testSRVConfig()
+	cfg.LDAPURL = "ldap://static:389"
+	servers := NewServerSet(cfg)
+	d := NewSRVDiscovery(cfg, servers)
+	d.resolver = &stubResolver{err: errors.New("no such host")}
+
+	if err := d.Refresh(context.Background()); GetLDAPErrorCode(err) != ErrConnectionFailed {
+		t.Errorf("expected ErrConnectionFailed, got %v", err)
+	}
+	if got := servers.URLs(); !reflect.DeepEqual(got, []string{"ldap://static:389"}) {
+		t.Errorf("expected static servers to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// signingKey is a private key loaded from TokenKeyFiles together with the
+// JWS algorithm implied by its type.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer crypto.Signer
+	jwk    map[string]string
+}
+
+// TokenIssuer signs JWTs for successful logins. The first configured key is
+// the active signing key; the remaining keys are only published in the JWKS
+// so tokens signed before a rotation keep validating until they expire.
+type TokenIssuer struct {
+	cfg *Config
+	now func() time.Time
+
+	mu   sync.RWMutex
+	keys []*signingKey
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewTokenIssuer(cfg *Config) (*TokenIssuer, error) {
+	t := &TokenIssuer{cfg: cfg, now: time.Now, stop: make(chan struct{})}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	if cfg.TokenKeyRefresh > 0 {
+		t.wg.Add(1)
+		go t.watch()
+	}
+	return t, nil
+}
+
+// Reload re-reads the key files, which allows keys to be rotated by
+// replacing the mounted files.
+func (t *TokenIssuer) Reload() error {
+	if len(t.cfg.TokenKeyFiles) == 0 {
+		return NewLDAPError(ErrInvalidConfig, "token issuance enabled but no signing keys configured")
+	}
+	keys := make([]*signingKey, 0, len(t.cfg.TokenKeyFiles))
+	for _, path := range t.cfg.TokenKeyFiles {
+		k, err := loadSigningKey(path)
+		if err != nil {
+			return NewLDAPErrorWithCause(ErrInvalidConfig, "failed to load token signing key "+path, err)
+		}
+		keys = append(keys, k)
+	}
+	t.mu.Lock()
+	t.keys = keys
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *TokenIssuer) watch() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.cfg.TokenKeyRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if err := t.Reload(); err != nil {
+				log.Error().Err(err).Msg("failed to reload token signing keys, keeping previous keys")
+			}
+		}
+	}
+}
+
+func (t *TokenIssuer) Close() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+// Issue signs a token for the given user. Claims are taken from attrs
+// according to TokenClaims; groups are added under TokenGroupsClaim when
+// non-nil. A non-empty client is the client whose own authorization rule
+// was applied; it replaces TokenAudience as the aud claim, so a token
+// obtained under a more lenient client's rule is rejected by other
+// relying parties.
+func (t *TokenIssuer) Issue(username string, attrs map[string]string, groups []string, client string) (string, time.Time, error) {
+	t.mu.RLock()
+	key := t.keys[0]
+	t.mu.RUnlock()
+
+	now := t.now()
+	exp := now.Add(t.cfg.TokenTTL)
+	claims := jwt.MapClaims{
+		"sub": username,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": exp.Unix(),
+	}
+	if t.cfg.TokenIssuer != "" {
+		claims["iss"] = t.cfg.TokenIssuer
+	}
+	switch {
+	case client != "":
+		claims["aud"] = client
+	case len(t.cfg.TokenAudience) == 0:
+	case len(t.cfg.TokenAudience) == 1:
+		claims["aud"] = t.cfg.TokenAudience[0]
+	default:
+		claims["aud"] = t.cfg.TokenAudience
+	}
+	for claim, attr := range t.cfg.TokenClaims {
+		if v, ok := attrs[attr]; ok {
+			claims[claim] = v
+		}
+	}
+	if groups != nil && t.cfg.TokenGroupsClaim != "" {
+		claims[t.cfg.TokenGroupsClaim] = groups
+	}
+
+	tok := jwt.NewWithClaims(key.method, claims)
+	tok.Header["kid"] = key.kid
+	signed, err := tok.SignedString(key.signer)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, exp, nil
+}
+
+// JWKS returns the public keys in JSON Web Key Set form.
+func (t *TokenIssuer) JWKS() map[string]any {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	keys := make([]map[string]string, 0, len(t.keys))
+	for _, k := range t.keys {
+		jwk := map[string]string{"kid": k.kid, "use": "sig", "alg": k.method.Alg()}
+		for name, v := range k.jwk {
+			jwk[name] = v
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]any{"keys": keys}
+}
+
+// GET /.well-known/jwks.json
+func JWKSHandler(tokens *TokenIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondJSON(w, http.StatusOK, tokens.JWKS())
+	}
+}
+
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var priv any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(priv)
+}
+
+func newSigningKey(priv any) (*signingKey, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	k := &signingKey{}
+	switch key := priv.(type) {
+	case *rsa.PrivateKey:
+		k.method = jwt.SigningMethodRS256
+		k.signer = key
+		k.jwk = map[string]string{
+			"kty": "RSA",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		var crv string
+		switch key.Curve {
+		case elliptic.P256():
+			k.method, crv = jwt.SigningMethodES256, "P-256"
+		case elliptic.P384():
+			k.method, crv = jwt.SigningMethodES384, "P-384"
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %s", key.Curve.Params().Name)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		k.signer = key
+		k.jwk = map[string]string{
+			"kty": "EC",
+			"crv": crv,
+			"x":   b64(key.X.FillBytes(make([]byte, size))),
+			"y":   b64(key.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PrivateKey:
+		k.method = jwt.SigningMethodEdDSA
+		k.signer = key
+		k.jwk = map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   b64(key.Public().(ed25519.PublicKey)),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", priv)
+	}
+
+	// RFC 7638 thumbprint: json.Marshal sorts map keys lexicographically
+	thumb, err := json.Marshal(k.jwk)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(thumb)
+	k.kid = b64(sum[:])
+	return k, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeTestKey(t *testing.T, dir, name string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func testTokenConfig(keys ...string) *Config {
+	return &Config{
+		TokenEnabled:     true,
+		TokenKeyFiles:    keys,
+		TokenIssuer:      "https://auth.example.com",
+		TokenAudience:    []string{"portal"},
+		TokenTTL:         time.Hour,
+		TokenClaims:      map[string]string{"email": "mail"},
+		TokenGroupsClaim: "groups",
+	}
+}
+
+func TestTokenIssuerAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+	}{
+		{"rsa", rsaKey, "RS256"},
+		{"ec", ecKey, "ES256"},
+		{"ed25519", edKey, "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := NewTokenIssuer(testTokenConfig(writeTestKey(t, dir, tt.name+".pem", tt.key)))
+			if err != nil {
+				t.Fatalf("NewTokenIssuer: %v", err)
+			}
+			defer issuer.Close()
+
+			signed, _, err := issuer.Issue("alice", map[string]string{"mail": "alice@example.com", "cn": "Alice"}, []string{"admins"}, "")
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			tok, err := jwt.Parse(signed, func(tok *jwt.Token) (any, error) {
+				return tt.key.Public(), nil
+			}, jwt.WithAudience("portal"), jwt.WithIssuer("https://auth.example.com"))
+			if err != nil {
+				t.Fatalf("token does not verify: %v", err)
+			}
+			if tok.Method.Alg() != tt.alg {
+				t.Errorf("expected alg %s, got %s", tt.alg, tok.Method.Alg())
+			}
+			claims := tok.Claims.(jwt.MapClaims)
+			if claims["sub"] != "alice" || claims["email"] != "alice@example.com" {
+				t.Errorf("unexpected claims %v", claims)
+			}
+			if _, ok := claims["cn"]; ok {
+				t.Error("unmapped attribute should not become a claim")
+			}
+			if groups, ok := claims["groups"].([]any); !ok || len(groups) != 1 || groups[0] != "admins" {
+				t.Errorf("expected groups claim, got %v", claims["groups"])
+			}
+		})
+	}
+}
+
+func TestTokenIssuerRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	active := writeTestKey(t, dir, "active.pem", oldKey)
+
+	issuer, err := NewTokenIssuer(testTokenConfig(active))
+	if err != nil {
+		t.Fatalf("NewTokenIssuer: %v", err)
+	}
+	defer issuer.Close()
+	oldKid := issuer.JWKS()["keys"].([]map[string]string)[0]["kid"]
+
+	// rotate: the new key becomes active and the old one is kept for verification
+	writeTestKey(t, dir, "active.pem", newKey)
+	previous := writeTestKey(t, dir, "previous.pem", oldKey)
+	issuer.cfg.TokenKeyFiles = []string{active, previous}
+	if err := issuer.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	keys := issuer.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 || keys[1]["kid"] != oldKid || keys[0]["kid"] == oldKid {
+		t.Fatalf("unexpected key set after rotation: %v", keys)
+	}
+	signed, _, err := issuer.Issue("alice", nil, nil, "")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	tok, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if tok.Header["kid"] != keys[0]["kid"] {
+		t.Errorf("expected token signed with the new key")
+	}
+}
+
+func TestTokenIssuerClientAudience(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer, err := NewTokenIssuer(testTokenConfig(writeTestKey(t, t.TempDir(), "key.pem", key)))
+	if err != nil {
+		t.Fatalf("NewTokenIssuer: %v", err)
+	}
+	defer issuer.Close()
+
+	signed, _, err := issuer.Issue("alice", nil, nil, "hr_portal")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	keyFunc := func(tok *jwt.Token) (any, error) { return key.Public(), nil }
+	if _, err := jwt.Parse(signed, keyFunc, jwt.WithAudience("portal")); err == nil {
+		t.Error("token issued under a client rule should not carry TOKEN_AUDIENCE")
+	}
+	if _, err := jwt.Parse(signed, keyFunc, jwt.WithAudience("hr_portal")); err != nil {
+		t.Errorf("expected client audience: %v", err)
+	}
+}
+
+func TestTokenIssuerRequiresKeys(t *testing.T) {
+	if _, err := NewTokenIssuer(testTokenConfig()); GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
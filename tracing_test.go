@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useInMemoryTracer installs an in-process exporter for the duration of a test.
+func useInMemoryTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prev)
+	})
+	return exp
+}
+
+func TestTracingMiddlewareContinuesTrace(t *testing.T) {
+	exp := useInMemoryTracer(t)
+
+	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
+	router.HandleFunc("/v1/users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("GET", "/v1/users/alice", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /v1/users/{username}" {
+		t.Errorf("unexpected span name %q", span.Name)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected incoming trace id to be continued, got %s", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("expected remote parent span, got %s", got)
+	}
+}
+
+func TestLDAPOperationSpans(t *testing.T) {
+	exp := useInMemoryTracer(t)
+
+	cfg := &Config{UserSearchBase: "dc=example,dc=com", UserSearchFilter: "(uid=%s)"}
+	c := &LDAPClient{cfg: cfg, conn: &fakeConn{searchFn: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("uid=alice,dc=example,dc=com", nil)}}, nil
+	}}}
+
+	ctx, parent := otel.Tracer(tracerName).Start(context.Background(), "request")
+	dn, _, err := c.FindUserDN(ctx, "alice")
+	if err != nil {
+		t.Fatalf("FindUserDN: %v", err)
+	}
+	if err := c.AuthenticateWithDN(ctx, dn, "pw"); err != nil {
+		t.Fatalf("AuthenticateWithDN: %v", err)
+	}
+	parent.End()
+
+	names := map[string]bool{}
+	for _, s := range exp.GetSpans() {
+		names[s.Name] = true
+		if s.Name != "request" && s.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the request span", s.Name)
+		}
+	}
+	for _, want := range []string{"ldap.search", "ldap.user_bind"} {
+		if !names[want] {
+			t.Errorf("missing span %s, got %v", want, names)
+		}
+	}
+}
+
+func TestTraceLDAPWithoutParent(t *testing.T) {
+	exp := useInMemoryTracer(t)
+	_, end := traceLDAP(context.Background(), opServiceBind)
+	end(nil)
+	if n := len(exp.GetSpans()); n != 0 {
+		t.Errorf("expected no span without a parent, got %d", n)
+	}
+}
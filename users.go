@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+type callerKey struct{}
+
+// LookupEnabled reports whether a caller authentication method for the
+// directory lookup endpoints is configured.
+func (c *Config) LookupEnabled() bool {
+	return len(c.LookupAPIKeys) > 0 || len(c.LookupClientNames) > 0
+}
+
+// requireLookupCaller admits callers of the lookup endpoints that present
+// a LOOKUP_API_KEYS entry as "Authorization: Bearer <key>", or a client
+// certificate verified against SERVICE_TLS_CLIENT_CA whose CN or DNS SAN is
+// listed in LOOKUP_CLIENT_NAMES.
+func requireLookupCaller(cfg *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := lookupCaller(cfg, r)
+		if caller == "" {
+			log.Warn().Str("ip", clientIP(cfg, r)).Str("path", r.URL.Path).Msg("rejected lookup request")
+			respondJSON(w, http.StatusUnauthorized, map[string]any{"ok": false, "error": "unauthorized"})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	}
+}
+
+// lookupCaller identifies an authenticated caller, or returns "".
+func lookupCaller(cfg *Config, r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && validAPIKey(cfg.LookupAPIKeys, key) {
+		return "key:" + keyFingerprint(key)
+	}
+	// 只接受已通过 CA 校验的证书链
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, allowed := range cfg.LookupClientNames {
+		for _, n := range names {
+			if n != "" && strings.EqualFold(n, allowed) {
+				return "cert:" + n
+			}
+		}
+	}
+	return ""
+}
+
+// requestCaller returns the caller set by requireLookupCaller.
+func requestCaller(r *http.Request) string {
+	caller, _ := r.Context().Value(callerKey{}).(string)
+	return caller
+}
+
+type UserResponse struct {
+	Ok     bool              `json:"ok"`
+	User   map[string]string `json:"user,omitempty"`
+	Groups []string          `json:"groups,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// GET /v1/users/{username}
+func UserLookupHandler(app *App) http.HandlerFunc {
+	cfg, pool := app.cfg, app.pool
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.RequestTimeout)
+		defer cancel()
+
+		client, err := pool.Get(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get ldap connection")
+			respondJSON(w, http.StatusInternalServerError, UserResponse{Ok: false, Error: "ldap_client_error"})
+			return
+		}
+		defer pool.Put(client)
+
+		userDN, attrs, err := client.findUser(ctx, username, cfg.LookupAttributes)
+		if err != nil {
+			code := GetLDAPErrorCode(err)
+			status := http.StatusInternalServerError
+			if code == ErrUserNotFound {
+				status = http.StatusNotFound
+			}
+			respondJSON(w, status, UserResponse{Ok: false, Error: authOutcome(err, ErrSearchFailed)})
+			return
+		}
+
+		resp := UserResponse{Ok: true, User: attrs}
+		if cfg.GroupMode != "" {
+			groups, err := client.GetUserGroups(ctx, userDN, username)
+			if err != nil {
+				log.Error().Err(err).Str("userDN", userDN).Msg("group lookup failed")
+				respondJSON(w, http.StatusInternalServerError, UserResponse{Ok: false, Error: "group_lookup_failed"})
+				return
+			}
+			resp.Groups = groupNames(cfg, groups)
+		}
+		log.Debug().Str("caller", requestCaller(r)).Str("user", username).Msg("user lookup")
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/gorilla/mux"
+)
+
+func TestLookupCaller(t *testing.T) {
+	cfg := &Config{LookupAPIKeys: []string{"k1"}, LookupClientNames: []string{"crm.internal"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/alice", nil)
+	r.Header.Set("Authorization", "Bearer k1")
+	if got := lookupCaller(cfg, r); got != "key:"+keyFingerprint("k1") {
+		t.Errorf("API key caller = %q", got)
+	}
+
+	r.Header.Set("Authorization", "Bearer nope")
+	if got := lookupCaller(cfg, r); got != "" {
+		t.Errorf("wrong key accepted as %q", got)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}, DNSNames: []string{"CRM.internal"}}
+	r = httptest.NewRequest(http.MethodGet, "/v1/users/alice", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if got := lookupCaller(cfg, r); got != "" {
+		t.Errorf("unverified certificate accepted as %q", got)
+	}
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	if got := lookupCaller(cfg, r); got != "cert:CRM.internal" {
+		t.Errorf("certificate caller = %q", got)
+	}
+	cert.DNSNames = []string{"other.internal"}
+	if got := lookupCaller(cfg, r); got != "" {
+		t.Errorf("certificate with unlisted name accepted as %q", got)
+	}
+}
+
+func TestUserLookupHandler(t *testing.T) {
+	var requested []string
+	app, _ := passwordChangeTestApp(t, func(fc *fakeConn) {
+		fc.searchFn = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			if !strings.Contains(req.Filter, "alice") {
+				return &ldap.SearchResult{}, nil
+			}
+			requested = req.Attributes
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+				"cn":              {"Alice"},
+				"telephoneNumber": {"+1 555 0100"},
+			})}}, nil
+		}
+	})
+	app.cfg.LookupAPIKeys = []string{"k1"}
+	app.cfg.LookupAttributes = []string{"cn", "telephoneNumber"}
+	app.cfg.ReturnAttributes = []string{"cn", "mail"}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/users/{username}", requireLookupCaller(app.cfg, UserLookupHandler(app))).Methods("GET")
+	get := func(path, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/v1/users/alice", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated lookup: status %d", w.Code)
+	}
+	w := get("/v1/users/alice", "k1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"telephoneNumber":"+1 555 0100"`) {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if !slices.Equal(requested, []string{"cn", "telephoneNumber"}) {
+		t.Errorf("requested attributes %v, want the lookup allow-list", requested)
+	}
+	if w := get("/v1/users/bob", "k1"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown user: status %d", w.Code)
+	}
+}
+
+// writeTestCert writes a self-signed certificate and key for host to dir.
+// The certificate is its own CA and is valid for both server and client
+// authentication.
+func writeTestCert(t *testing.T, dir, host string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	if tc, err := serverTLSConfig(&Config{}); tc != nil || err != nil {
+		t.Fatalf("without certificate: %v, %v", tc, err)
+	}
+	if _, err := serverTLSConfig(&Config{ServiceTLSClientCA: "ca.pem"}); GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Fatalf("client CA without certificate: err = %v", err)
+	}
+
+	certFile, keyFile, _ := writeTestCert(t, t.TempDir(), "test")
+	tc, err := serverTLSConfig(&Config{ServiceTLSCert: certFile, ServiceTLSKey: keyFile, ServiceTLSClientCA: certFile})
+	if err != nil {
+		t.Fatalf("serverTLSConfig: %v", err)
+	}
+	if len(tc.Certificates) != 1 || tc.ClientAuth != tls.VerifyClientCertIfGiven || tc.ClientCAs == nil {
+		t.Errorf("unexpected TLS config %+v", tc)
+	}
+}
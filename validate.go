@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// ConfigErrors lists every problem found by Config.Validate, each prefixed
+// with the environment variable (or lower-case file key) at fault.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate checks the configuration as a whole and reports all problems at
+// once rather than stopping at the first. The returned error is an
+// ErrInvalidConfig LDAPError wrapping ConfigErrors.
+func (c *Config) Validate() error {
+	var problems ConfigErrors
+	add := func(key, format string, args ...any) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if n, err := strconv.Atoi(c.ServicePort); err != nil || n < 1 || n > 65535 {
+		add("SERVICE_PORT", "%q is not a valid port", c.ServicePort)
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.LogLevel)) {
+		add("LOG_LEVEL", "unknown level %q, use debug, info, warn or error", c.LogLevel)
+	}
+
+	// LDAP 连接
+	if c.UseLDAPS && c.UseStartTLS {
+		add("LDAP_USE_LDAPS", "cannot be combined with LDAP_USE_STARTTLS, choose one")
+	}
+	urls := c.ServerURLs()
+	if len(urls) == 0 && c.LDAPSRVDomain == "" {
+		add("LDAP_URL", "no LDAP server configured, set LDAP_URL, LDAP_URLS or LDAP_SRV_DOMAIN")
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" && u.Scheme != "ldapi" {
+			add("LDAP_URL", "%q is not a valid URL, expected e.g. ldap://host:389", raw)
+			continue
+		}
+		switch {
+		case u.Scheme != "ldap" && u.Scheme != "ldaps" && u.Scheme != "ldapi":
+			add("LDAP_URL", "%q has unsupported scheme %q, use ldap:// or ldaps://", raw, u.Scheme)
+		case u.Scheme == "ldap" && c.UseLDAPS:
+			add("LDAP_URL", "%q is plain ldap:// but LDAP_USE_LDAPS=1, use ldaps:// or LDAP_USE_STARTTLS", raw)
+		case u.Scheme == "ldaps" && c.UseStartTLS:
+			add("LDAP_URL", "%q is already TLS (ldaps://) and cannot use LDAP_USE_STARTTLS", raw)
+		}
+	}
+	usesTLS := c.UseLDAPS || c.UseStartTLS
+	for _, raw := range urls {
+		usesTLS = usesTLS || strings.HasPrefix(raw, "ldaps://")
+	}
+	switch c.BindMethod {
+	case BindMethodSimple:
+		if c.BindDN != "" && c.BindPassword == "" {
+			add("LDAP_BIND_PASSWORD", "required when LDAP_BIND_DN is set, otherwise searches run anonymously")
+		}
+		if c.BindDN == "" && c.BindPassword != "" {
+			add("LDAP_BIND_DN", "required when LDAP_BIND_PASSWORD is set")
+		}
+	case BindMethodExternal:
+		if c.BindDN != "" || c.BindPassword != "" {
+			add("LDAP_BIND_METHOD", "external takes the identity from the client certificate, unset LDAP_BIND_DN and LDAP_BIND_PASSWORD")
+		}
+		for _, raw := range urls {
+			if !strings.HasPrefix(raw, "ldapi://") && (!usesTLS || c.LDAPTLSCert == "") {
+				add("LDAP_BIND_METHOD", "external needs LDAP_TLS_CERT over LDAPS or StartTLS for %q, or an ldapi:// URL", raw)
+			}
+		}
+		if len(urls) == 0 && (!usesTLS || c.LDAPTLSCert == "") {
+			add("LDAP_BIND_METHOD", "external needs LDAP_TLS_CERT over LDAPS or StartTLS")
+		}
+	case BindMethodNTLM:
+		if c.DirectoryType != DirectoryAD {
+			add("LDAP_BIND_METHOD", "ntlm requires LDAP_DIRECTORY_TYPE=%s", DirectoryAD)
+		}
+		if _, user := splitDomainUser(c.BindDN); user == "" {
+			add("LDAP_BIND_DN", `required for ntlm, as DOMAIN\user or user`)
+		}
+		if (c.BindPassword == "") == (c.BindNTHash == "") {
+			add("LDAP_BIND_PASSWORD", "ntlm needs exactly one of LDAP_BIND_PASSWORD and LDAP_BIND_NT_HASH")
+		}
+		if c.BindNTHash != "" && !validNTHash(c.BindNTHash) {
+			// 不输出哈希本身
+			add("LDAP_BIND_NT_HASH", "must be 32 hexadecimal characters")
+		}
+	default:
+		add("LDAP_BIND_METHOD", "unknown bind method %q, use %s, %s or %s", c.BindMethod, BindMethodSimple, BindMethodExternal, BindMethodNTLM)
+	}
+	if c.BindNTHash != "" && c.BindMethod != BindMethodNTLM {
+		add("LDAP_BIND_NT_HASH", "only used with LDAP_BIND_METHOD=%s", BindMethodNTLM)
+	}
+	if len(c.UserNTLMDomains) > 0 && c.DirectoryType != DirectoryAD {
+		add("LDAP_USER_NTLM_DOMAINS", "requires LDAP_DIRECTORY_TYPE=%s", DirectoryAD)
+	}
+	validateNTLMBases(c, add)
+	if c.DirectoryType != DirectoryOpenLDAP && c.DirectoryType != DirectoryAD {
+		add("LDAP_DIRECTORY_TYPE", "unknown directory type %q, use %s or %s", c.DirectoryType, DirectoryOpenLDAP, DirectoryAD)
+	}
+	if c.ConnTimeout <= 0 {
+		add("LDAP_CONN_TIMEOUT", "must be positive")
+	}
+	if c.RequestTimeout <= 0 {
+		add("LDAP_REQUEST_TIMEOUT", "must be positive")
+	}
+	if !slices.Contains([]string{StrategyFailover, StrategyRoundRobin, StrategyLeastLatency}, c.ServerStrategy) {
+		add("LDAP_SERVER_STRATEGY", "unknown strategy %q, use %s, %s or %s", c.ServerStrategy, StrategyFailover, StrategyRoundRobin, StrategyLeastLatency)
+	}
+
+	// LDAP TLS
+	if (c.LDAPTLSCert == "") != (c.LDAPTLSKey == "") {
+		add("LDAP_TLS_CERT", "LDAP_TLS_CERT and LDAP_TLS_KEY must be set together")
+	}
+	for key, f := range map[string]string{"LDAP_TLS_CA_FILE": c.LDAPTLSCAFile, "LDAP_TLS_CERT": c.LDAPTLSCert, "LDAP_TLS_KEY": c.LDAPTLSKey} {
+		if f == "" {
+			continue
+		}
+		checkReadable(add, key, f)
+		if !usesTLS {
+			add(key, "has no effect without LDAP_USE_LDAPS, LDAP_USE_STARTTLS or an ldaps:// URL")
+		}
+	}
+	if c.InsecureSkipVerify && c.LDAPTLSCAFile != "" {
+		add("LDAP_INSECURE_SKIP_VERIFY", "skips verification against LDAP_TLS_CA_FILE, unset one of them")
+	}
+	if _, err := parseTLSVersion(c.LDAPTLSMinVersion); err != nil {
+		add("LDAP_TLS_MIN_VERSION", "%v", err)
+	}
+	if _, err := parseCipherSuites(c.LDAPTLSCipherSuites); err != nil {
+		add("LDAP_TLS_CIPHER_SUITES", "%v", err)
+	}
+	if _, err := parseSPKIPins(c.LDAPTLSPins); err != nil {
+		add("LDAP_TLS_PINS", "%v", err)
+	}
+
+	// 连接池
+	if c.PoolMaxOpen < 1 {
+		add("LDAP_POOL_MAX_OPEN", "must be at least 1")
+	}
+	if c.PoolMaxIdle > c.PoolMaxOpen {
+		add("LDAP_POOL_MAX_IDLE", "%d exceeds LDAP_POOL_MAX_OPEN (%d)", c.PoolMaxIdle, c.PoolMaxOpen)
+	}
+	if c.PoolMinIdle > c.PoolMaxIdle {
+		add("LDAP_POOL_MIN_IDLE", "%d exceeds LDAP_POOL_MAX_IDLE (%d)", c.PoolMinIdle, c.PoolMaxIdle)
+	}
+
+	// 用户和组搜索
+	if c.UserSearchBase == "" {
+		add("LDAP_USER_BASE", "must not be empty")
+	}
+	if n := strings.Count(c.UserSearchFilter, "%s"); n != 1 {
+		add("LDAP_USER_FILTER", "must contain %%s exactly once for the username, found %d in %q", n, c.UserSearchFilter)
+	} else if strings.Count(c.UserSearchFilter, "%") != 1 {
+		add("LDAP_USER_FILTER", "%q contains %% other than %%s, escape literal percent signs as \\25", c.UserSearchFilter)
+	} else if _, err := ldap.CompileFilter(fmt.Sprintf(c.UserSearchFilter, "user")); err != nil {
+		add("LDAP_USER_FILTER", "%q is not a valid LDAP filter: %v", c.UserSearchFilter, err)
+	}
+	if _, err := ldap.CompileFilter(c.SearchGroupFilter); err != nil {
+		add("SEARCH_GROUP_FILTER", "%q is not a valid LDAP filter: %v", c.SearchGroupFilter, err)
+	}
+	switch c.GroupMode {
+	case "", GroupModeMemberOf, GroupModeMember, GroupModeMemberUID, GroupModeADNested:
+	default:
+		add("LDAP_GROUP_MODE", "unknown mode %q, use %s, %s, %s or %s", c.GroupMode, GroupModeMemberOf, GroupModeMember, GroupModeMemberUID, GroupModeADNested)
+	}
+	if c.GroupMode == "" {
+		// 不查询组时用户组始终为空：拒绝列表永远不会命中，允许列表拒绝所有人
+		for client, r := range c.AuthzRules {
+			if r.Empty() {
+				continue
+			}
+			name := authzDefaultKey
+			if client != "" {
+				name = client
+			}
+			add("LDAP_GROUP_MODE", "required by the %s group authorization rule, users' groups are not looked up without it", name)
+		}
+	}
+	if c.GroupFormat != GroupFormatName && c.GroupFormat != GroupFormatDN {
+		add("LDAP_GROUP_FORMAT", "unknown format %q, use %s or %s", c.GroupFormat, GroupFormatName, GroupFormatDN)
+	}
+
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		add("TRACING_SAMPLE_RATIO", "%g is outside 0-1", c.TracingSampleRatio)
+	}
+
+	if c.TokenEnabled {
+		if len(c.TokenKeyFiles) == 0 {
+			add("TOKEN_SIGNING_KEYS", "required when TOKEN_ENABLED=1")
+		}
+		if c.TokenTTL <= 0 {
+			add("TOKEN_TTL", "must be positive")
+		}
+		for _, f := range c.TokenKeyFiles {
+			checkReadable(add, "TOKEN_SIGNING_KEYS", f)
+		}
+	}
+
+	for _, sink := range c.AuditSinks {
+		switch sink {
+		case "file", "syslog":
+		case "webhook":
+			if c.AuditWebhookURL == "" {
+				add("AUDIT_WEBHOOK_URL", "required by the webhook audit sink")
+			}
+		default:
+			add("AUDIT_SINKS", "unknown sink %q, use file, syslog or webhook", sink)
+		}
+	}
+
+	for _, p := range c.TrustedProxies {
+		if _, err := parseProxyPrefix(p); err != nil {
+			add("TRUSTED_PROXIES", "%q is not an IP address or CIDR", p)
+		}
+	}
+	if len(c.TrustedProxies) > 0 && !c.TrustProxyHeaders {
+		add("TRUSTED_PROXIES", "has no effect without TRUST_PROXY_HEADERS")
+	}
+
+	if c.RateLimitEnabled && c.RateLimitWindow <= 0 {
+		add("RATE_LIMIT_WINDOW", "must be positive when rate limiting is enabled")
+	}
+
+	if c.LookupEnabled() {
+		if c.SearchPageSize < 1 || c.SearchPageSize > c.SearchMaxPageSize {
+			add("SEARCH_PAGE_SIZE", "%d must be between 1 and SEARCH_MAX_PAGE_SIZE (%d)", c.SearchPageSize, c.SearchMaxPageSize)
+		}
+		if c.SearchCursorTTL <= 0 {
+			add("SEARCH_CURSOR_TTL", "must be positive")
+		}
+		if c.SearchMaxCursors < 0 {
+			add("SEARCH_MAX_CURSORS", "must not be negative")
+		}
+		if c.SearchMaxCursors > 0 && (c.SearchCallerCursors < 1 || c.SearchCallerCursors > c.SearchMaxCursors) {
+			add("SEARCH_CALLER_CURSORS", "%d must be between 1 and SEARCH_MAX_CURSORS (%d)", c.SearchCallerCursors, c.SearchMaxCursors)
+		}
+		if c.GroupMembersTimeout <= 0 {
+			add("GROUP_MEMBERS_TIMEOUT", "must be positive")
+		}
+	}
+
+	// HTTP 服务端
+	for key, d := range map[string]time.Duration{"HTTP_READ_TIMEOUT": c.HTTPReadTimeout, "HTTP_WRITE_TIMEOUT": c.HTTPWriteTimeout, "HTTP_IDLE_TIMEOUT": c.HTTPIdleTimeout} {
+		if d < 0 {
+			add(key, "must not be negative, use 0 for no timeout")
+		}
+	}
+	if c.HTTPWriteTimeout > 0 && c.HTTPWriteTimeout <= c.RequestTimeout {
+		add("HTTP_WRITE_TIMEOUT", "%s must be longer than LDAP_REQUEST_TIMEOUT (%s) or responses are cut off", c.HTTPWriteTimeout, c.RequestTimeout)
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("SHUTDOWN_TIMEOUT", "must be positive")
+	}
+
+	// 服务端 TLS
+	if (c.ServiceTLSCert == "") != (c.ServiceTLSKey == "") {
+		add("SERVICE_TLS_CERT", "SERVICE_TLS_CERT and SERVICE_TLS_KEY must be set together")
+	}
+	if c.ServiceTLSClientCA != "" && c.ServiceTLSCert == "" {
+		add("SERVICE_TLS_CLIENT_CA", "requires SERVICE_TLS_CERT")
+	}
+	if len(c.LookupClientNames) > 0 && c.ServiceTLSClientCA == "" {
+		add("LOOKUP_CLIENT_NAMES", "requires SERVICE_TLS_CLIENT_CA to verify client certificates")
+	}
+	for key, f := range map[string]string{"SERVICE_TLS_CERT": c.ServiceTLSCert, "SERVICE_TLS_KEY": c.ServiceTLSKey, "SERVICE_TLS_CLIENT_CA": c.ServiceTLSClientCA} {
+		if f != "" {
+			checkReadable(add, key, f)
+		}
+	}
+
+	if c.VaultKVVersion != 1 && c.VaultKVVersion != 2 {
+		add("VAULT_KV_VERSION", "%d is not a KV engine version, use 1 or 2", c.VaultKVVersion)
+	}
+
+	return problems.err()
+}
+
+// err returns nil without problems, or the sorted problems as an
+// ErrInvalidConfig LDAPError.
+func (e ConfigErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	slices.Sort(e)
+	return NewLDAPErrorWithCause(ErrInvalidConfig, fmt.Sprintf("%d configuration problem(s)", len(e)), e)
+}
+
+// validateNTLMBases checks that users of different LDAP_USER_NTLM_DOMAINS
+// are looked up in separate subtrees. Users are found by name without the
+// domain, so with a shared base an account of one domain could be matched
+// for a user of another.
+func validateNTLMBases(c *Config, add func(key, format string, args ...any)) {
+	for d, base := range c.UserNTLMBases {
+		if !slices.ContainsFunc(c.UserNTLMDomains, func(s string) bool { return strings.EqualFold(s, d) }) {
+			add("LDAP_USER_NTLM_BASES", "%s is not listed in LDAP_USER_NTLM_DOMAINS", d)
+		}
+		if _, err := ldap.ParseDN(base); err != nil || base == "" {
+			add("LDAP_USER_NTLM_BASES", "%q for %s is not a valid DN", base, d)
+		}
+	}
+	if len(c.UserNTLMDomains) < 2 {
+		return
+	}
+	hasBase := func(domain string) bool {
+		for d := range c.UserNTLMBases {
+			if strings.EqualFold(d, domain) {
+				return true
+			}
+		}
+		return false
+	}
+	bases := make([]*ldap.DN, len(c.UserNTLMDomains))
+	for i, d := range c.UserNTLMDomains {
+		if !hasBase(d) {
+			add("LDAP_USER_NTLM_BASES", "needs a search base for %s, LDAP_USER_NTLM_DOMAINS lists several domains", d)
+			continue
+		}
+		bases[i], _ = ldap.ParseDN(c.ntlmUserBase(d))
+	}
+	for i := range bases {
+		for j := i + 1; j < len(bases); j++ {
+			a, b := bases[i], bases[j]
+			if a == nil || b == nil {
+				continue
+			}
+			if a.EqualFold(b) || a.AncestorOfFold(b) || b.AncestorOfFold(a) {
+				add("LDAP_USER_NTLM_BASES", "search bases of %s and %s overlap, their users cannot be told apart", c.UserNTLMDomains[i], c.UserNTLMDomains[j])
+			}
+		}
+	}
+}
+
+func checkReadable(add func(key, format string, args ...any), key, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		add(key, "cannot read %s: %v", path, errors.Unwrap(err))
+		return
+	}
+	f.Close()
+}
+
+// writeConfigError prints a load or validation error one problem per line.
+func writeConfigError(w io.Writer, err error) {
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		fmt.Fprintf(w, "invalid configuration: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "invalid configuration, %d problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(w, "  - %s\n", p)
+	}
+}
+
+// loadValidConfig loads the configuration and validates it.
+func loadValidConfig(path string) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// checkConfig implements the check-config subcommand and returns the
+// process exit code.
+func checkConfig(path string, stdout, stderr io.Writer) int {
+	if _, err := loadValidConfig(path); err != nil {
+		writeConfigError(stderr, err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "configuration OK")
+	return 0
+}
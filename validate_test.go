@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	c := defaultConfig()
+	c.normalize()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+}
+
+func TestValidateCollectsAllProblems(t *testing.T) {
+	c := defaultConfig()
+	c.LDAPURLs = []string{"ldap://dc1.example.com", "ldaps://dc2.example.com", "http://dc3.example.com"}
+	c.UseLDAPS = true
+	c.UseStartTLS = true
+	c.UserSearchFilter = "(uid=user)"
+	c.DirectoryType = "novell"
+	c.PoolMaxIdle = 20
+	c.BindDN = "cn=svc,dc=example,dc=com"
+	c.TokenEnabled = true
+	c.AuditSinks = []string{"webhook", "kafka"}
+	c.LookupClientNames = []string{"crm"}
+	c.ServiceTLSCert = filepath.Join(t.TempDir(), "missing.pem")
+
+	err := c.Validate()
+	if GetLDAPErrorCode(err) != ErrInvalidConfig {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ConfigErrors in %v", err)
+	}
+	for _, want := range []string{
+		"LDAP_USE_LDAPS: cannot be combined with LDAP_USE_STARTTLS",
+		`LDAP_URL: "ldap://dc1.example.com" is plain ldap://`,
+		`LDAP_URL: "ldaps://dc2.example.com" is already TLS`,
+		`LDAP_URL: "http://dc3.example.com" has unsupported scheme`,
+		"LDAP_USER_FILTER: must contain %s exactly once",
+		"LDAP_DIRECTORY_TYPE: unknown directory type",
+		"LDAP_POOL_MAX_IDLE: 20 exceeds LDAP_POOL_MAX_OPEN",
+		"LDAP_BIND_PASSWORD: required",
+		"TOKEN_SIGNING_KEYS: required",
+		"AUDIT_WEBHOOK_URL: required",
+		`AUDIT_SINKS: unknown sink "kafka"`,
+		"LOOKUP_CLIENT_NAMES: requires SERVICE_TLS_CLIENT_CA",
+		"SERVICE_TLS_CERT: SERVICE_TLS_CERT and SERVICE_TLS_KEY must be set together",
+		"SERVICE_TLS_CERT: cannot read",
+	} {
+		found := false
+		for _, p := range problems {
+			found = found || strings.HasPrefix(p, want)
+		}
+		if !found {
+			t.Errorf("missing problem %q in:\n%s", want, strings.Join(problems, "\n"))
+		}
+	}
+	if len(problems) != 14 {
+		t.Errorf("expected 14 problems, got %d:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+}
+
+func TestValidateUserFilter(t *testing.T) {
+	for filter, ok := range map[string]bool{
+		"(uid=%s)": true,
+		"(&(objectClass=user)(sAMAccountName=%s))": true,
+		"(|(uid=%s)(mail=%s))":                     false,
+		"uid=%s":                                   false,
+		"(&(uid=%s)(cn=100%))":                     false,
+		"(&(uid=%s)(cn=100\\25))":                  true,
+	} {
+		c := defaultConfig()
+		c.UserSearchFilter = filter
+		if err := c.Validate(); (err == nil) != ok {
+			t.Errorf("filter %s: valid=%v, err=%v", filter, ok, err)
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := checkConfig(writeConfigFile(t, "ok.yaml", "service_port: \"9000\"\n"), &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d, stderr %s", code, stderr.String())
+	}
+	if stdout.String() != "configuration OK\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+
+	stdout.Reset()
+	path := writeConfigFile(t, "bad.yaml", "ldap_use_ldaps: true\nldap_use_starttls: true\nldap_user_filter: (uid=x)\n")
+	if code := checkConfig(path, &stdout, &stderr); code != 1 {
+		t.Fatalf("exit %d, want 1", code)
+	}
+	out := stderr.String()
+	if !strings.HasPrefix(out, "invalid configuration, 3 problem(s):\n  - ") || !strings.Contains(out, "  - LDAP_USER_FILTER: ") {
+		t.Errorf("stderr = %s", out)
+	}
+
+	stderr.Reset()
+	if code := checkConfig(writeConfigFile(t, "typo.yaml", "ldap_ur1: x\n"), &stdout, &stderr); code != 1 || !strings.Contains(stderr.String(), "ldap_ur1") {
+		t.Errorf("exit %d, stderr %s", code, stderr.String())
+	}
+}
+
+func TestValidateHTTPTimeouts(t *testing.T) {
+	c := defaultConfig()
+	c.HTTPWriteTimeout = 5 * time.Second
+	c.HTTPIdleTimeout = -time.Second
+	c.ShutdownTimeout = 0
+	err := c.Validate()
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 3 {
+		t.Fatalf("expected 3 problems, got %v", err)
+	}
+	if !strings.HasPrefix(problems[1], "HTTP_WRITE_TIMEOUT: 5s must be longer than LDAP_REQUEST_TIMEOUT (8s)") {
+		t.Errorf("problems = %q", problems)
+	}
+
+	c = defaultConfig()
+	c.HTTPWriteTimeout = 0
+	if err := c.Validate(); err != nil {
+		t.Errorf("0 disables the write timeout: %v", err)
+	}
+}
+
+func TestValidateBindMethodExternal(t *testing.T) {
+	c := defaultConfig()
+	c.BindMethod = BindMethodExternal
+	c.BindDN = "cn=svc,dc=example,dc=com"
+	c.BindPassword = "secret"
+	err := c.Validate()
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", err)
+	}
+	if !strings.Contains(problems[0], "needs LDAP_TLS_CERT") || !strings.Contains(problems[1], "unset LDAP_BIND_DN") {
+		t.Errorf("problems = %q", problems)
+	}
+
+	certFile, keyFile, _ := writeTestCert(t, t.TempDir(), "test")
+	c = defaultConfig()
+	c.BindMethod = "EXTERNAL"
+	c.normalize()
+	c.LDAPURLs = []string{"ldaps://dc1.example.com", "ldapi:///var/run/slapd/ldapi"}
+	c.LDAPTLSCert, c.LDAPTLSKey = certFile, keyFile
+	if err := c.Validate(); err != nil {
+		t.Errorf("client certificate over LDAPS should be valid: %v", err)
+	}
+
+	c.BindMethod = "kerberos"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "unknown bind method") {
+		t.Errorf("err = %v", err)
+	}
+}